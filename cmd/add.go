@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/remote"
+
+	"github.com/spf13/cobra"
+)
+
+var addCmd = &cobra.Command{
+	Use:   "add <paths>...",
+	Short: "stage the current content of files for the next commit",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkNoConflicts(); err != nil {
+			return err
+		}
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			idx, err := history.LoadIndex()
+			if err != nil {
+				return err
+			}
+
+			staged, err := r.Stage(idx, args)
+			for _, p := range staged {
+				log.Printf("staged %s\n", p)
+			}
+			if err != nil {
+				return errors.Join(errors.New("failed to stage files"), err)
+			}
+			return idx.Save()
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(addCmd)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+
+	"github.com/spf13/cobra"
+)
+
+var branchCmd = &cobra.Command{
+	Use:   "branch [-d] [<name> [<commit>]]",
+	Short: "list, create or delete branches",
+	Long: `Without arguments the branches on the remote are listed. With a name a new
+branch is created at <commit>, the checked out commit by default. The branch
+is not switched to, use scribe switch for that.`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			switch {
+			case options.FlagDelete:
+				if len(args) != 1 {
+					return errors.New("branch -d needs exactly one branch name")
+				}
+				if args[0] == c.CurrentBranch() {
+					return fmt.Errorf("branch %s is checked out, switch to another branch first", args[0])
+				}
+				if err := r.DeleteBranch(args[0]); err != nil {
+					return err
+				}
+				log.Printf("deleted branch %s\n", args[0])
+				return nil
+
+			case len(args) == 0:
+				branches, err := r.Branches()
+				if err != nil {
+					return err
+				}
+				for _, b := range branches {
+					if b == c.CurrentBranch() {
+						fmt.Printf("* %s\n", b)
+					} else {
+						fmt.Printf("  %s\n", b)
+					}
+				}
+				return nil
+			}
+
+			var start *history.Commit
+			var err error
+			if len(args) == 2 {
+				log.Println("pull commits from remote")
+				if err := r.PullCommits(); err != nil {
+					return errors.Join(errors.New("failed to pull commits"), err)
+				}
+				start, err = r.ResolveCommit(args[1])
+			} else {
+				start, err = c.CurrentCommit()
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := r.CreateBranch(args[0], start); err != nil {
+				return errors.Join(errors.New("failed to create branch"), err)
+			}
+			log.Printf("created branch %s at commit %x\n", args[0], start.Created)
+			return nil
+		})
+	},
+}
+
+func init() {
+	branchCmd.Flags().BoolVarP(&options.FlagDelete, "delete", "d", false, "delete the branch, its commits stay on the remote")
+	rootCmd.AddCommand(branchCmd)
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+	"scribe/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+var checkoutCmd = &cobra.Command{
+	Use:   "checkout [<commit>] [--at <date>]",
+	Short: "move the working tree to a commit",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("Invalid count of arguments for checkout: %d. Usage: checkout [<commit>] [--at <date>]", len(args))
+		}
+		if len(args) == 0 && options.FlagAt == "" {
+			return errors.New("checkout needs a commit or --at <date>")
+		}
+
+		if err := checkNoConflicts(); err != nil {
+			return err
+		}
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			log.Println("pull commits from remote")
+			if err := r.PullCommits(); err != nil {
+				return errors.Join(errors.New("failed to pull commits"), err)
+			}
+
+			log.Println("get head commit from remote")
+			head, err := r.GetHeadCommit()
+			if err != nil {
+				return errors.Join(errors.New("failed to get head commit from remote"), err)
+			}
+
+			target := head
+			if len(args) == 1 {
+				if target, err = r.ResolveCommit(args[0]); err != nil {
+					return err
+				}
+			}
+
+			if options.FlagAt != "" {
+				at, err := util.ParseTime(options.FlagAt)
+				if err != nil {
+					return err
+				}
+				h, err := history.LoadAll()
+				if err != nil {
+					return errors.Join(errors.New("failed to load history"), err)
+				}
+				found, ok := h.At(target.Created, at.Unix())
+				if !ok {
+					return fmt.Errorf("no commit at or before %s", util.FormatTime(at.Unix()))
+				}
+				if target, err = history.Load(found.Created); err != nil {
+					return errors.Join(errors.New("failed to load commit"), err)
+				}
+			}
+
+			if err := verifyHead(r, target); err != nil {
+				return err
+			}
+
+			log.Printf("checkout commit %x\n", target.Created)
+			err = r.CheckoutCommit(target)
+			var conflict *remote.ConflictError
+			if err != nil && !errors.As(err, &conflict) {
+				return errors.Join(errors.New("failed to checkout commit"), err)
+			}
+
+			c.Detached = target.Created != head.Created
+			if err := c.Save(); err != nil {
+				return errors.Join(errors.New("failed to save config"), err)
+			}
+			if c.Detached {
+				log.Printf("commit %x is detached from HEAD %x, run scribe pull to return to it\n", target.Created, head.Created)
+			}
+
+			reportConflicts(err)
+			return err
+		})
+	},
+}
+
+func init() {
+	checkoutCmd.Flags().StringVar(&options.FlagAt, "at", options.FlagAt, "checkout the newest commit created at or before this date")
+	rootCmd.AddCommand(checkoutCmd)
+}
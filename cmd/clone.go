@@ -89,6 +89,10 @@ var cloneCmd = &cobra.Command{
 			return errors.Join(errors.New("failed to get head commit"), err)
 		}
 
+		if err := verifyHead(r, head); err != nil {
+			return err
+		}
+
 		log.Printf("checkout commit %x\n", head.Created)
 		if err := r.CloneCommit(head); err != nil {
 			return errors.Join(errors.New("failed to checkout commit"), err)
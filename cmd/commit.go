@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"scribe/internal/config"
+	"scribe/internal/history"
 	"scribe/internal/options"
 	"scribe/internal/remote"
 	"strings"
@@ -13,52 +15,168 @@ import (
 )
 
 var commitCmd = &cobra.Command{
-	Use:     "commit",
+	Use:     "commit [<paths>...]",
 	Aliases: []string{"push"},
-	Short:   "commit changes to remote",
+	Short:   "commit changes to remote, optionally only of the given paths",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		log.Println("load local config")
-		c, err := config.Load()
-		if err != nil {
-			return errors.Join(errors.New("failed to load config"), err)
-		}
-
-		log.Println("connect to remote")
-		r, err := remote.Connect(c)
-		if err != nil {
-			return errors.Join(errors.New("failed to connect to remote"), err)
+		if err := checkNoConflicts(); err != nil {
+			return err
 		}
-		defer r.Close()
 
-		msg := strings.Join(options.FlagMessage, "\n")
-		if len(options.FlagMessage) == 0 {
-			if err := huh.NewForm(huh.NewGroup(
-				huh.NewText().
-					Title("commit message").
-					ShowLineNumbers(true).
-					Validate(func(s string) error {
-						if len(s) != 0 {
-							return nil
-						} else {
-							return errors.New("message must not be empty")
-						}
-					}).
-					Value(&msg),
-			)).Run(); err != nil {
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			idx, err := history.LoadIndex()
+			if err != nil {
 				return err
 			}
+			if !idx.Empty() && len(args) != 0 {
+				return errors.New("there are staged changes, commit them without paths or unstage them first")
+			}
+
+			push := remote.PushReject
+			if options.FlagForce {
+				push = remote.PushForce
+			} else if c.Detached {
+				publish := false
+				if err := huh.NewForm(huh.NewGroup(
+					huh.NewConfirm().
+						Title(fmt.Sprintf("commit %x is not the remote HEAD", c.Commit)).
+						Description("Publishing now makes this old state the new HEAD and drops newer changes from it.").
+						Affirmative("Publish anyway").
+						Negative("Cancel").
+						Value(&publish),
+				)).Run(); err != nil {
+					return errors.Join(errors.New("checked out commit is detached from HEAD, run scribe pull or use --force"), err)
+				}
+				if !publish {
+					return errors.New("checked out commit is detached from HEAD, run scribe pull or use --force")
+				}
+				push = remote.PushForce
+			}
+
+			msg := strings.Join(options.FlagMessage, "\n")
+			if len(options.FlagMessage) == 0 {
+				if err := huh.NewForm(huh.NewGroup(
+					huh.NewText().
+						Title("commit message").
+						ShowLineNumbers(true).
+						Validate(func(s string) error {
+							if len(s) != 0 {
+								return nil
+							} else {
+								return errors.New("message must not be empty")
+							}
+						}).
+						Value(&msg),
+				)).Run(); err != nil {
+					return err
+				}
+			}
+
+			if options.FlagRebase {
+				err = commitRebased(r, idx, msg, args)
+			} else {
+				err = commitChanges(r, idx, msg, args, push)
+			}
+			var rejected *remote.RejectedError
+			if errors.As(err, &rejected) {
+				choice := ""
+				if perr := huh.NewForm(huh.NewGroup(
+					huh.NewSelect[string]().
+						Title(fmt.Sprintf("branch %s moved on to commit %x", rejected.Branch, rejected.Head)).
+						Description("Publishing the commit as it is would drop the newer commits from the branch.").
+						Options(
+							huh.NewOption("Merge their changes", "merge"),
+							huh.NewOption("Rebase onto their changes", "rebase"),
+							huh.NewOption("Cancel", ""),
+						).
+						Value(&choice),
+				)).Run(); perr != nil {
+					// without a terminal the rejection is what matters
+					return errors.Join(errors.New("failed to create commit"), err, perr)
+				}
+				switch choice {
+				case "merge":
+					err = commitChanges(r, idx, msg, args, remote.PushMerge)
+				case "rebase":
+					err = commitRebased(r, idx, msg, args)
+				default:
+					return errors.Join(errors.New("failed to create commit"), err)
+				}
+			}
+
+			var conflict *remote.MergeConflictError
+			if errors.As(err, &conflict) {
+				log.Println(err)
+				return mergeHead(r)
+			}
+			if err != nil {
+				return errors.Join(errors.New("failed to create commit"), err)
+			}
+			return nil
+		})
+	},
+}
+
+// commitChanges publishes the staged changes, or the working tree when
+// nothing is staged.
+func commitChanges(r *remote.Remote, idx *history.Index, msg string, paths []string, push remote.Push) error {
+	if !idx.Empty() {
+		log.Printf("creating commit of %d staged changes\n", len(idx.Entries))
+		return r.CommitStaged(msg, idx, push)
+	}
+	log.Println("creating commit")
+	return r.Commit(msg, paths, push)
+}
+
+// maxRebases limits how often commitRebased follows a head that keeps moving.
+const maxRebases = 5
+
+// commitRebased publishes the local changes and rebases them onto the
+// remote head whenever it moved on meanwhile. It stops on conflicts, see
+// scribe pull --continue.
+func commitRebased(r *remote.Remote, idx *history.Index, msg string, paths []string) error {
+	for i := 0; ; i++ {
+		err := commitChanges(r, idx, msg, paths, remote.PushReject)
+		var rejected *remote.RejectedError
+		if !errors.As(err, &rejected) || i == maxRebases {
+			return err
 		}
 
-		log.Println("creating commit")
-		if err := r.Commit(msg); err != nil {
-			return errors.Join(errors.New("failed to create initial commit"), err)
+		if err := r.PullCommits(); err != nil {
+			return errors.Join(errors.New("failed to pull commits"), err)
 		}
+		head, err := r.GetHeadCommit()
+		if err != nil {
+			return errors.Join(errors.New("failed to get head commit from remote"), err)
+		}
+		if err := verifyHead(r, head); err != nil {
+			return err
+		}
+		log.Printf("rebase local changes onto commit %x\n", head.Created)
+		if _, err := r.Rebase(head, msg, paths); err != nil {
+			reportConflicts(err)
+			return errors.Join(errors.New("failed to rebase"), err)
+		}
+	}
+}
 
-		return nil
-	},
+// mergeHead merges the remote head into the checked out commit, which stops
+// on the conflicts to resolve.
+func mergeHead(r *remote.Remote) error {
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return errors.Join(errors.New("failed to get head commit from remote"), err)
+	}
+	log.Printf("merge commit %x into %x\n", head.Created, r.Config.Commit)
+	if _, err := r.Merge(head, ""); err != nil {
+		reportConflicts(err)
+		return errors.Join(errors.New("failed to merge"), err)
+	}
+	return nil
 }
 
 func init() {
+	commitCmd.Flags().BoolVar(&options.FlagRebase, "rebase", false, "replay the changes on top of the remote head when it moved on and publish them there")
 	commitCmd.Flags().StringArrayVarP(&options.FlagMessage, "message", "m", options.FlagMessage, "Use the given value as the commit message. If multiple -m options are given, their values are concatenated as separate paragraphs.")
 	rootCmd.AddCommand(commitCmd)
 }
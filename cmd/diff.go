@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"scribe/internal/config"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+	"scribe/internal/remote"
+	"scribe/internal/util"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// maxDiffSize is the size above which files are summarized instead of diffed.
+const maxDiffSize = 8 * 1024 * 1024
+
+// diffSide is one side of a file diff, either from a commit or from the
+// working tree (commit is nil).
+type diffSide struct {
+	commit *history.Commit
+	file   history.CommitFile
+	exists bool
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [<commit>] [<commit>] [-- <paths>...]",
+	Short: "show line changes between the working tree and a commit, or between two commits",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var paths []string
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			paths = args[dash:]
+			args = args[:dash]
+		}
+		if len(args) > 2 {
+			return fmt.Errorf("Invalid count of arguments for diff: %d. At most 2 allowed: diff [<commit>] [<commit>] [-- <paths>...]", len(args))
+		}
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			log.Println("pull commits from remote")
+			if err := r.PullCommits(); err != nil {
+				return errors.Join(errors.New("failed to pull commits"), err)
+			}
+
+			var from, to *history.Commit
+			var err error
+			if len(args) == 0 {
+				from, err = c.CurrentCommit()
+			} else {
+				from, err = r.ResolveCommit(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			if len(args) == 2 {
+				if to, err = r.ResolveCommit(args[1]); err != nil {
+					return err
+				}
+			}
+
+			var changes diff.DiffList
+			if to == nil {
+				if changes, err = diff.LocalFromCommit(c, from); err != nil {
+					return errors.Join(errors.New("failed to diff local changes"), err)
+				}
+				slices.SortFunc(changes, func(a, b diff.Diff) int {
+					return strings.Compare(a.Path, b.Path)
+				})
+			} else {
+				changes = diff.Commits(from, to)
+			}
+			if len(paths) != 0 {
+				changes = changes.Filter(paths)
+			}
+
+			for _, d := range changes {
+				old := diffSide{commit: from}
+				old.file, old.exists = from.File(d.Path)
+				cur := diffSide{commit: to}
+				if to != nil {
+					cur.file, cur.exists = to.File(d.Path)
+				} else if d.Type != diff.DiffTypeDelete {
+					absPath := filepath.Join(r.LocalWD(), filepath.FromSlash(d.Path))
+					fi, err := os.Lstat(absPath)
+					if err != nil {
+						return errors.Join(errors.New("failed to stat file"), err)
+					}
+					if cur.file, err = diff.Stat(c, absPath, d.Path, fi); err != nil {
+						return err
+					}
+					cur.exists = true
+				}
+				if err := printFileDiff(r, d.Path, old, cur); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	},
+}
+
+func printFileDiff(r *remote.Remote, p string, old diffSide, cur diffSide) error {
+	fmt.Printf("diff a/%s b/%s\n", p, p)
+	switch {
+	case !old.exists && cur.file.Mode != 0:
+		fmt.Printf("new file mode %o\n", cur.file.Mode)
+	case !cur.exists && old.file.Mode != 0:
+		fmt.Printf("deleted file mode %o\n", old.file.Mode)
+	case old.exists && cur.exists && old.file.Executable() != cur.file.Executable():
+		fmt.Printf("old mode %o\nnew mode %o\n", old.file.Mode, cur.file.Mode)
+	}
+
+	if old.file.Type == history.FileTypeDir || cur.file.Type == history.FileTypeDir {
+		fmt.Println("empty directory")
+		return nil
+	}
+	if old.file.Size > maxDiffSize || cur.file.Size > maxDiffSize {
+		fmt.Printf("Large files a/%s and b/%s differ (%s -> %s)\n", p, p, util.FormatSize(old.file.Size), util.FormatSize(cur.file.Size))
+		return nil
+	}
+
+	oldContent, err := diffContent(r, old)
+	if err != nil {
+		return err
+	}
+	curContent, err := diffContent(r, cur)
+	if err != nil {
+		return err
+	}
+	if diff.IsBinary(oldContent) || diff.IsBinary(curContent) {
+		fmt.Printf("Binary files a/%s and b/%s differ (%s -> %s)\n", p, p, util.FormatSize(int64(len(oldContent))), util.FormatSize(int64(len(curContent))))
+		return nil
+	}
+
+	aName, bName := "a/"+p, "b/"+p
+	if !old.exists {
+		aName = "/dev/null"
+	}
+	if !cur.exists {
+		bName = "/dev/null"
+	}
+	fmt.Print(diff.Unified(aName, bName, diff.SplitLines(string(oldContent)), diff.SplitLines(string(curContent)), 3))
+	return nil
+}
+
+func diffContent(r *remote.Remote, side diffSide) ([]byte, error) {
+	if !side.exists {
+		return nil, nil
+	}
+	if side.commit != nil {
+		b, err := r.ObjectContent(side.file)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to read %s of commit %x", side.file.Path, side.commit.Created), err)
+		}
+		return b, nil
+	}
+
+	absPath := filepath.Join(r.LocalWD(), filepath.FromSlash(side.file.Path))
+	if side.file.Type == history.FileTypeSymlink {
+		target, err := os.Readlink(absPath)
+		if err != nil {
+			return nil, errors.Join(errors.New("failed to read symlink"), err)
+		}
+		return []byte(target), nil
+	}
+	b, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to read file"), err)
+	}
+	return b, nil
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+}
@@ -46,6 +46,7 @@ var initCmd = &cobra.Command{
 			c = &config.Config{Ignore: config.DefaultIgnore}
 		}
 		port := "22"
+		var passphrase string
 		if err := huh.NewForm(huh.NewGroup(
 			huh.NewInput().
 				Title("Host").
@@ -73,6 +74,11 @@ var initCmd = &cobra.Command{
 			huh.NewInput().
 				Title("Path").
 				Value(&c.Path),
+			huh.NewInput().
+				Title("Encryption passphrase").
+				Description("Leave empty to store the repository unencrypted").
+				EchoMode(huh.EchoModePassword).
+				Value(&passphrase),
 		)).Run(); err != nil {
 			return err
 		}
@@ -83,7 +89,7 @@ var initCmd = &cobra.Command{
 		}
 
 		log.Println("connect to remote")
-		r, err := remote.Connect(c)
+		r, err := remote.Dial(c)
 		if err != nil {
 			return errors.Join(errors.New("failed to connect to remote"), err)
 		}
@@ -109,6 +115,21 @@ var initCmd = &cobra.Command{
 			return errors.Join(errors.New("failed to save config"), err)
 		}
 
+		if len(passphrase) != 0 {
+			log.Println("initialize repository encryption")
+			if err := r.InitEncryption(passphrase); err != nil {
+				return errors.Join(errors.New("failed to initialize encryption"), err)
+			}
+			if err := c.SaveKey(); err != nil {
+				return errors.Join(errors.New("failed to save repository key"), err)
+			}
+		} else {
+			log.Println("write repository format")
+			if err := r.WriteFormat(remote.CurrentFormat()); err != nil {
+				return errors.Join(errors.New("failed to write repository format"), err)
+			}
+		}
+
 		log.Println("create inital commit")
 		if err := r.InitialCommit(); err != nil {
 			return errors.Join(errors.New("failed to create initial commit"), err)
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"scribe/internal/config"
+	"scribe/internal/crypt"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+)
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "manage the keys of encrypted repositories",
+}
+
+var keyGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "generate a personal identity and print its public recipient",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ip, err := config.DefaultIdentityPath()
+		if err != nil {
+			return errors.Join(errors.New("failed to get identity path"), err)
+		}
+		if c, err := config.Load(); err == nil {
+			if ip, err = c.IdentityPath(); err != nil {
+				return errors.Join(errors.New("failed to get identity path"), err)
+			}
+		}
+
+		if _, err := os.Stat(ip); err == nil && !options.FlagForce {
+			return fmt.Errorf("identity %s already exists", ip)
+		}
+
+		id, err := crypt.NewIdentity()
+		if err != nil {
+			return errors.Join(errors.New("failed to generate identity"), err)
+		}
+		if err := os.MkdirAll(filepath.Dir(ip), 0700); err != nil {
+			return errors.Join(errors.New("failed to create identity directory"), err)
+		}
+		if err := os.WriteFile(ip, []byte(id.String()+"\n"), 0600); err != nil {
+			return errors.Join(errors.New("failed to write identity"), err)
+		}
+
+		log.Printf("identity written to %s\n", ip)
+		fmt.Println(id.Recipient())
+		return nil
+	},
+}
+
+var keyShareCmd = &cobra.Command{
+	Use:   "share <recipient>",
+	Short: "share the repository key with the owner of a recipient",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return shareKey(func(key *crypt.Key) (crypt.Stanza, error) {
+			return key.WrapRecipient(args[0])
+		})
+	},
+}
+
+var keyPassphraseCmd = &cobra.Command{
+	Use:   "passphrase",
+	Short: "add another passphrase that unlocks the repository",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var passphrase string
+		if err := huh.NewForm(huh.NewGroup(
+			huh.NewInput().
+				Title("New passphrase").
+				EchoMode(huh.EchoModePassword).
+				Validate(func(s string) error {
+					if len(s) != 0 {
+						return nil
+					} else {
+						return errors.New("passphrase must not be empty")
+					}
+				}).
+				Value(&passphrase),
+		)).Run(); err != nil {
+			return err
+		}
+		return shareKey(func(key *crypt.Key) (crypt.Stanza, error) {
+			return key.WrapPassphrase(passphrase)
+		})
+	},
+}
+
+var keyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list who can unlock the repository",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			if !r.Encrypted() {
+				return errors.New("repository is not encrypted")
+			}
+
+			keys, err := r.ReadKeys()
+			if err != nil {
+				return errors.Join(errors.New("failed to read keys"), err)
+			}
+			for _, s := range keys.Stanzas {
+				switch s.Type {
+				case crypt.StanzaTypePassphrase:
+					fmt.Println("passphrase")
+				case crypt.StanzaTypeRecipient:
+					fmt.Println(s.Recipient)
+				}
+			}
+			return nil
+		})
+	},
+}
+
+func shareKey(wrap func(key *crypt.Key) (crypt.Stanza, error)) error {
+	return withRemote(func(c *config.Config, r *remote.Remote) error {
+		if !r.Encrypted() {
+			return errors.New("repository is not encrypted")
+		}
+
+		s, err := wrap(c.Key)
+		if err != nil {
+			return errors.Join(errors.New("failed to wrap repository key"), err)
+		}
+
+		log.Println("write keys to remote")
+		if err := r.ShareKey(s); err != nil {
+			return errors.Join(errors.New("failed to share repository key"), err)
+		}
+		return nil
+	})
+}
+
+func init() {
+	keyCmd.AddCommand(keyGenerateCmd, keyShareCmd, keyPassphraseCmd, keyListCmd)
+	rootCmd.AddCommand(keyCmd)
+}
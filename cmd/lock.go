@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/remote"
+
+	"github.com/spf13/cobra"
+)
+
+var lockCmd = &cobra.Command{
+	Use:   "lock <paths>...",
+	Short: "lock files so only you can commit changes to them",
+	Long: `Locks are meant for files that can't be merged, like binary assets. Until
+they are unlocked with scribe unlock, commits of others that change the
+files are refused.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			locked, err := r.LockPaths(args)
+			if err != nil {
+				return errors.Join(errors.New("failed to lock files"), err)
+			}
+			for _, p := range locked {
+				log.Printf("locked %s\n", p)
+			}
+			if len(locked) == 0 {
+				log.Println("all files were locked by you already")
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+}
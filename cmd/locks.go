@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"scribe/internal/config"
+	"scribe/internal/remote"
+	"scribe/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+var locksCmd = &cobra.Command{
+	Use:   "locks",
+	Short: "list locked files, * marks your locks",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			locks, err := r.PathLocks()
+			if err != nil {
+				return errors.Join(errors.New("failed to read locks"), err)
+			}
+			for _, l := range locks.Locks {
+				mark := " "
+				if l.Owner == c.User {
+					mark = "*"
+				}
+				fmt.Printf("%s %s  %s on %s since %s\n", mark, l.Path, l.Owner, l.Host, util.FormatTime(l.Created))
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(locksCmd)
+}
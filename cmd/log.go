@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"scribe/internal/config"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+	"scribe/internal/util"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log [<commit>] [-- <paths>...]",
+	Short: "show commit history",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var paths []string
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			paths = args[dash:]
+			args = args[:dash]
+		}
+		if len(args) > 1 {
+			return fmt.Errorf("Invalid count of arguments for log: %d. At most 1 allowed: log [<commit>] [-- <paths>...]", len(args))
+		}
+
+		var since, until int64
+		if len(options.FlagSince) != 0 {
+			t, err := util.ParseTime(options.FlagSince)
+			if err != nil {
+				return err
+			}
+			since = t.Unix()
+		}
+		if len(options.FlagUntil) != 0 {
+			t, err := util.ParseTime(options.FlagUntil)
+			if err != nil {
+				return err
+			}
+			until = t.Unix()
+		}
+		var grep *regexp.Regexp
+		if len(options.FlagGrep) != 0 {
+			var err error
+			if grep, err = regexp.Compile("(?i)" + options.FlagGrep); err != nil {
+				return errors.Join(errors.New("invalid --grep pattern"), err)
+			}
+		}
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			log.Println("pull commits from remote")
+			if err := r.PullCommits(); err != nil {
+				return errors.Join(errors.New("failed to pull commits"), err)
+			}
+
+			head, err := r.GetHeadCommit()
+			if err != nil {
+				return errors.Join(errors.New("failed to get head commit"), err)
+			}
+
+			start := []int64{head.Created, c.Commit}
+			if len(args) == 1 {
+				sc, err := r.ResolveCommit(args[0])
+				if err != nil {
+					return err
+				}
+				start = []int64{sc.Created}
+			}
+
+			h, err := history.LoadAll()
+			if err != nil {
+				return errors.Join(errors.New("failed to load history"), err)
+			}
+
+			emptyHash, err := c.HashReader(strings.NewReader(""))
+			if err != nil {
+				return err
+			}
+
+			printed := 0
+			for _, commit := range h.Log(start...) {
+				if options.FlagLimit > 0 && printed >= options.FlagLimit {
+					break
+				}
+				if (since != 0 && commit.Created < since) || (until != 0 && commit.Created > until) {
+					continue
+				}
+				if grep != nil && !grep.MatchString(commit.Message) {
+					continue
+				}
+
+				if err := commit.LoadFiles(); err != nil {
+					return err
+				}
+				var parent *history.Commit
+				if len(commit.Parents) != 0 {
+					if p, ok := h.Get(commit.Parents[0]); ok {
+						if err := p.LoadFiles(); err != nil {
+							return err
+						}
+						parent = p
+					}
+				}
+				if len(paths) != 0 && len(diff.Commits(parent, commit).Filter(paths)) == 0 {
+					commit.UnloadFiles()
+					continue
+				}
+
+				printLogEntry(commit, parent, head.Created, c.Commit, emptyHash)
+				commit.UnloadFiles()
+				printed++
+			}
+
+			return nil
+		})
+	},
+}
+
+func printLogEntry(commit *history.Commit, parent *history.Commit, head int64, checkedOut int64, emptyHash string) {
+	var marks []string
+	if commit.Created == head {
+		marks = append(marks, "HEAD")
+	}
+	if commit.Created == checkedOut {
+		marks = append(marks, "checked out")
+	}
+	if len(marks) != 0 {
+		fmt.Printf("commit %x (%s)\n", commit.Created, strings.Join(marks, ", "))
+	} else {
+		fmt.Printf("commit %x\n", commit.Created)
+	}
+	if len(commit.Parents) > 1 {
+		ps := make([]string, len(commit.Parents))
+		for i, p := range commit.Parents {
+			ps[i] = fmt.Sprintf("%x", p)
+		}
+		fmt.Printf("Merge: %s\n", strings.Join(ps, " "))
+	}
+	fmt.Printf("Date:  %s\n", util.FormatTime(commit.Created))
+
+	files := fmt.Sprintf("Files: %d", len(commit.Files))
+	if size, ok := commit.Size(emptyHash); ok {
+		if parent == nil {
+			files += fmt.Sprintf(" (%s)", util.FormatSize(size))
+		} else if parentSize, ok := parent.Size(emptyHash); ok {
+			delta := size - parentSize
+			sign := "+"
+			if delta < 0 {
+				sign = ""
+			}
+			files += fmt.Sprintf(" (%s%s)", sign, util.FormatSize(delta))
+		}
+	}
+	fmt.Println(files)
+	fmt.Println()
+	for _, line := range strings.Split(strings.TrimRight(commit.Message, "\n"), "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+	fmt.Println()
+}
+
+func init() {
+	logCmd.Flags().StringVar(&options.FlagSince, "since", "", "only show commits after a date (YYYY-MM-DD [HH:MM[:SS]]) or duration ago (36h)")
+	logCmd.Flags().StringVar(&options.FlagUntil, "until", "", "only show commits before a date (YYYY-MM-DD [HH:MM[:SS]]) or duration ago (36h)")
+	logCmd.Flags().StringVar(&options.FlagGrep, "grep", "", "only show commits whose message matches a regular expression")
+	logCmd.Flags().IntVarP(&options.FlagLimit, "limit", "n", 0, "show at most this many commits")
+	rootCmd.AddCommand(logCmd)
+}
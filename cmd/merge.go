@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge (<commit>|--continue|--abort)",
+	Short: "merge a commit or branch into the checked out commit and publish the merge",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if options.FlagContinue || options.FlagAbort {
+			return withRemote(continueMerge)
+		}
+		if len(args) != 1 {
+			return errors.New("merge needs the commit to merge")
+		}
+		if err := checkNoConflicts(); err != nil {
+			return err
+		}
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			log.Println("pull commits from remote")
+			if err := r.PullCommits(); err != nil {
+				return errors.Join(errors.New("failed to pull commits"), err)
+			}
+
+			theirs, err := r.ResolveCommit(args[0])
+			if err != nil {
+				return err
+			}
+			if err := verifyHead(r, theirs); err != nil {
+				return err
+			}
+
+			log.Printf("merge commit %x into %x\n", theirs.Created, c.Commit)
+			merged, err := r.Merge(theirs, strings.Join(options.FlagMessage, "\n"))
+			if err != nil {
+				reportConflicts(err)
+				return errors.Join(errors.New("failed to merge"), err)
+			}
+			log.Printf("checked out commit %x\n", merged.Created)
+			return nil
+		})
+	},
+}
+
+// continueMerge creates the merge commit of a merge that stopped on
+// conflicts, or aborts it.
+func continueMerge(c *config.Config, r *remote.Remote) error {
+	state, ok, err := history.LoadConflictState()
+	if err != nil {
+		return err
+	}
+	if !ok || state.Operation != history.OperationMerge {
+		return errors.New("there is no interrupted merge")
+	}
+
+	if options.FlagAbort {
+		log.Printf("abort merge of commit %x\n", state.To)
+		if err := r.AbortMerge(state); err != nil {
+			return errors.Join(errors.New("failed to abort"), err)
+		}
+		return nil
+	}
+
+	err = r.ContinueMerge(state, strings.Join(options.FlagMessage, "\n"))
+	reportConflicts(err)
+	return err
+}
+
+func init() {
+	mergeCmd.Flags().BoolVar(&options.FlagContinue, "continue", false, "create the merge commit after the conflicts were resolved")
+	mergeCmd.Flags().BoolVar(&options.FlagAbort, "abort", false, "discard a merge that stopped on conflicts")
+	mergeCmd.Flags().StringArrayVarP(&options.FlagMessage, "message", "m", options.FlagMessage, "Use the given value as the message of the merge commit.")
+	rootCmd.AddCommand(mergeCmd)
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "upgrade the remote repository to the current format",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Println("load local config")
+		c, err := config.Load()
+		if err != nil {
+			return errors.Join(errors.New("failed to load config"), err)
+		}
+
+		log.Println("connect to remote")
+		r, err := remote.Dial(c)
+		if err != nil {
+			return errors.Join(errors.New("failed to connect to remote"), err)
+		}
+		defer r.Close()
+
+		if err := r.Migrate(options.FlagDryRun, func(msg string) {
+			fmt.Println(msg)
+		}); err != nil {
+			return errors.Join(errors.New("failed to migrate remote repository"), err)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&options.FlagDryRun, "dry-run", false, "only print what would be changed")
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"log"
 	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
 	"scribe/internal/remote"
 
 	"github.com/spf13/cobra"
@@ -13,40 +15,119 @@ var pullCmd = &cobra.Command{
 	Use:   "pull",
 	Short: "pull latest changes from remote",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		log.Println("load local config")
-		c, err := config.Load()
-		if err != nil {
-			return errors.Join(errors.New("failed to load config"), err)
-		}
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			if options.FlagContinue || options.FlagAbort {
+				return continuePull(r)
+			}
+			if err := checkNoConflicts(); err != nil {
+				return err
+			}
 
-		log.Println("connect to remote")
-		r, err := remote.Connect(c)
-		if err != nil {
-			return errors.Join(errors.New("failed to connect to remote"), err)
-		}
+			log.Println("pull commits from remote")
+			if err := r.PullCommits(); err != nil {
+				return errors.Join(errors.New("failed to pull commits"), err)
+			}
 
-		defer r.Close()
+			log.Println("read file locks from remote")
+			if _, err := r.PathLocks(); err != nil {
+				return errors.Join(errors.New("failed to read locks"), err)
+			}
 
-		log.Println("pull commits from remote")
-		if err := r.PullCommits(); err != nil {
-			return errors.Join(errors.New("failed to pull commits"), err)
-		}
+			log.Printf("get head commit of branch %s from remote\n", c.CurrentBranch())
+			head, err := r.GetHeadCommit()
+			if err != nil {
+				return errors.Join(errors.New("failed to get head commit from remote"), err)
+			}
 
-		log.Println("get head commit from remote")
-		head, err := r.GetHeadCommit()
-		if err != nil {
-			return errors.Join(errors.New("failed to get head commit from remote"), err)
-		}
+			if err := verifyHead(r, head); err != nil {
+				return err
+			}
+
+			if options.FlagRebase {
+				log.Printf("rebase local changes onto commit %x\n", head.Created)
+				msg, err := r.Rebase(head, "", nil)
+				if err != nil {
+					reportConflicts(err)
+					return errors.Join(errors.New("failed to rebase"), err)
+				}
+				return publishRebased(r, msg, nil)
+			}
+
+			if h, err := history.LoadAll(); err != nil {
+				return errors.Join(errors.New("failed to load history"), err)
+			} else if !h.IsAncestor(c.Commit, head.Created) {
+				log.Printf("local commit %x is not an ancestor of remote head %x, history has diverged\n", c.Commit, head.Created)
+			}
+
+			log.Printf("checkout commit %x\n", head.Created)
+			err = r.CheckoutCommit(head)
+			var conflict *remote.ConflictError
+			if err != nil && !errors.As(err, &conflict) {
+				return errors.Join(errors.New("failed to checkout commit"), err)
+			}
+
+			if c.Detached {
+				c.Detached = false
+				if err := c.Save(); err != nil {
+					return errors.Join(errors.New("failed to save config"), err)
+				}
+			}
 
-		log.Printf("checkout commit %x\n", head.Created)
-		if err := r.CheckoutCommit(head); err != nil {
-			return errors.Join(errors.New("failed to checkout commit"), err)
+			reportConflicts(err)
+			return err
+		})
+	},
+}
+
+// continuePull continues or aborts a checkout or rebase that stopped on
+// conflicts. A continued rebase publishes the replayed changes.
+func continuePull(r *remote.Remote) error {
+	state, ok, err := history.LoadConflictState()
+	if err != nil {
+		return err
+	}
+	if !ok || (state.Operation != history.OperationCheckout && state.Operation != history.OperationRebase) {
+		return errors.New("there is no interrupted pull")
+	}
+
+	if options.FlagAbort {
+		log.Printf("abort %s onto commit %x\n", state.Operation, state.To)
+		if err := r.AbortCheckout(state); err != nil {
+			return errors.Join(errors.New("failed to abort"), err)
 		}
+		return nil
+	}
+
+	if err := r.ContinueCheckout(state); err != nil {
+		reportConflicts(err)
+		return err
+	}
+	if state.Operation == history.OperationRebase {
+		return publishRebased(r, state.Message, state.Paths)
+	}
+	return nil
+}
 
+// publishRebased publishes the changes a rebase replayed, unless there is no
+// message for them because they were not committed before.
+func publishRebased(r *remote.Remote, msg string, paths []string) error {
+	if msg == "" {
 		return nil
-	},
+	}
+	idx, err := history.LoadIndex()
+	if err != nil {
+		return err
+	}
+	log.Println("publish the rebased changes")
+	if err := commitRebased(r, idx, msg, paths); err != nil {
+		return errors.Join(errors.New("failed to create commit"), err)
+	}
+	return nil
 }
 
 func init() {
+	pullCmd.Flags().BoolVar(&options.FlagContinue, "continue", false, "finish a pull after its conflicts were resolved")
+	pullCmd.Flags().BoolVar(&options.FlagRebase, "rebase", false, "replay local changes and commits that are not on the branch on top of the remote head and publish the commits")
+	pullCmd.Flags().BoolVar(&options.FlagAbort, "abort", false, "return to the state from before a pull that stopped on conflicts")
 	rootCmd.AddCommand(pullCmd)
 }
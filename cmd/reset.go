@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+
+	"github.com/spf13/cobra"
+)
+
+var resetCmd = &cobra.Command{
+	Use:   "reset [--hard] [<commit>]",
+	Short: "discard local changes and optionally move the local commit pointer",
+	Long: `Without --hard only the local commit pointer is moved to <commit> and the
+working tree is left alone. With --hard all local changes against the commit
+are discarded: modified and deleted files are restored and untracked files
+that are not ignored are removed.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && !options.FlagHard {
+			return errors.New("reset needs a commit or --hard")
+		}
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			var target *history.Commit
+			var err error
+			if len(args) == 0 {
+				target, err = c.CurrentCommit()
+			} else {
+				log.Println("pull commits from remote")
+				if err := r.PullCommits(); err != nil {
+					return errors.Join(errors.New("failed to pull commits"), err)
+				}
+				target, err = r.ResolveCommit(args[0])
+			}
+			if err != nil {
+				return err
+			}
+
+			c.Ignore = target.Ignore
+			if options.FlagHard {
+				log.Printf("reset working tree to commit %x\n", target.Created)
+				if err := r.Reset(target); err != nil {
+					return errors.Join(errors.New("failed to reset working tree"), err)
+				}
+
+				state, ok, err := history.LoadConflictState()
+				if err != nil {
+					return err
+				}
+				if ok {
+					log.Printf("discard the conflicts of the interrupted %s\n", state.Operation)
+					if state.Stash != 0 {
+						log.Println("the local changes from before it stay in scribe stash list")
+					}
+					if err := state.Remove(); err != nil {
+						return err
+					}
+				}
+			}
+
+			if target.Created == c.Commit {
+				return nil
+			}
+
+			log.Println("get head commit from remote")
+			head, err := r.GetHeadCommit()
+			if err != nil {
+				return errors.Join(errors.New("failed to get head commit from remote"), err)
+			}
+
+			c.Commit = target.Created
+			c.Detached = target.Created != head.Created
+			if err := c.Save(); err != nil {
+				return errors.Join(errors.New("failed to save config"), err)
+			}
+			log.Printf("local commit is now %x\n", target.Created)
+			return nil
+		})
+	},
+}
+
+func init() {
+	resetCmd.Flags().BoolVar(&options.FlagHard, "hard", false, "discard all local changes against the commit")
+	rootCmd.AddCommand(resetCmd)
+}
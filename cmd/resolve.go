@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+
+	"github.com/spf13/cobra"
+)
+
+var resolveCmd = &cobra.Command{
+	Use:   "resolve (--ours|--theirs|--merged) <paths>...",
+	Short: "resolve conflicts of an interrupted pull or merge",
+	Long: `Without paths the conflicting files are listed. --ours takes the local
+version, --theirs the incoming one and --merged the file as it is in the
+working tree, after the conflict markers were removed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state, ok, err := history.LoadConflictState()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("there are no conflicts to resolve")
+		}
+
+		if len(args) == 0 {
+			for _, f := range state.Files {
+				if f.Resolved {
+					fmt.Printf("resolved  %s\n", f.Path)
+				} else {
+					fmt.Printf("conflict  %s\n", f.Path)
+				}
+			}
+			return nil
+		}
+
+		var how string
+		switch {
+		case options.FlagOurs && !options.FlagTheirs && !options.FlagMerged:
+			how = remote.ResolveOurs
+		case options.FlagTheirs && !options.FlagOurs && !options.FlagMerged:
+			how = remote.ResolveTheirs
+		case options.FlagMerged && !options.FlagOurs && !options.FlagTheirs:
+			how = remote.ResolveMerged
+		default:
+			return errors.New("use exactly one of --ours, --theirs and --merged")
+		}
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			for _, p := range args {
+				if err := r.Resolve(state, p, how, options.FlagForce); err != nil {
+					return err
+				}
+				log.Printf("resolved %s\n", p)
+			}
+			if unresolved := state.Unresolved(); len(unresolved) != 0 {
+				log.Printf("%d conflicts left\n", len(unresolved))
+			} else {
+				log.Printf("all conflicts are resolved, run scribe %s --continue\n", continueCommand(state.Operation))
+			}
+			return nil
+		})
+	},
+}
+
+// continueCommand is the command that continues or aborts the interrupted
+// operation.
+func continueCommand(operation string) string {
+	if operation == history.OperationMerge {
+		return "merge"
+	}
+	return "pull"
+}
+
+// checkNoConflicts refuses to start an operation while another one waits
+// for its conflicts to be resolved.
+func checkNoConflicts() error {
+	state, ok, err := history.LoadConflictState()
+	if err != nil {
+		return err
+	}
+	if ok {
+		name := continueCommand(state.Operation)
+		return fmt.Errorf("a %s stopped on conflicts, resolve them with scribe resolve and run scribe %s --continue, or scribe %s --abort", state.Operation, name, name)
+	}
+	return nil
+}
+
+// reportConflicts explains how to go on when err says an operation stopped
+// on conflicts.
+func reportConflicts(err error) {
+	var conflict *remote.ConflictError
+	if !errors.As(err, &conflict) {
+		return
+	}
+	for _, p := range conflict.Paths {
+		log.Printf("conflict: %s\n", p)
+	}
+	name := continueCommand(conflict.Operation)
+	log.Printf("resolve the conflicts with scribe resolve, then run scribe %s --continue, or scribe %s --abort to go back\n", name, name)
+}
+
+func init() {
+	resolveCmd.Flags().BoolVar(&options.FlagOurs, "ours", false, "take the local version")
+	resolveCmd.Flags().BoolVar(&options.FlagTheirs, "theirs", false, "take the incoming version")
+	resolveCmd.Flags().BoolVar(&options.FlagMerged, "merged", false, "take the file from the working tree")
+	rootCmd.AddCommand(resolveCmd)
+}
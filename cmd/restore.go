@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [--source <commit>] <paths>...",
+	Short: "restore files from a commit, discarding local changes to them",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkNoConflicts(); err != nil {
+			return err
+		}
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			var source *history.Commit
+			var err error
+			if options.FlagSource == "" {
+				source, err = c.CurrentCommit()
+			} else {
+				log.Println("pull commits from remote")
+				if err := r.PullCommits(); err != nil {
+					return errors.Join(errors.New("failed to pull commits"), err)
+				}
+				source, err = r.ResolveCommit(options.FlagSource)
+			}
+			if err != nil {
+				return err
+			}
+
+			log.Printf("restore from commit %x\n", source.Created)
+			restored, err := r.Restore(source, args)
+			for _, p := range restored {
+				log.Printf("restored %s\n", p)
+			}
+			if err != nil {
+				return errors.Join(errors.New("failed to restore files"), err)
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	restoreCmd.Flags().StringVarP(&options.FlagSource, "source", "s", options.FlagSource, "commit to restore the files from, defaults to the checked out commit")
+	rootCmd.AddCommand(restoreCmd)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var revertCmd = &cobra.Command{
+	Use:   "revert <commit>",
+	Short: "publish a new commit that undoes the changes of a commit",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkNoConflicts(); err != nil {
+			return err
+		}
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			log.Println("pull commits from remote")
+			if err := r.PullCommits(); err != nil {
+				return errors.Join(errors.New("failed to pull commits"), err)
+			}
+
+			log.Println("get head commit from remote")
+			head, err := r.GetHeadCommit()
+			if err != nil {
+				return errors.Join(errors.New("failed to get head commit from remote"), err)
+			}
+
+			target, err := r.ResolveCommit(args[0])
+			if err != nil {
+				return err
+			}
+
+			atHead := c.Commit == head.Created
+			log.Printf("revert commit %x on top of %x\n", target.Created, head.Created)
+			revert, conflicts, err := r.Revert(target, head, strings.Join(options.FlagMessage, "\n"), options.FlagForce)
+			for _, p := range conflicts {
+				log.Printf("conflict: %s was changed after commit %x\n", p, target.Created)
+			}
+			if err != nil {
+				return errors.Join(errors.New("failed to revert commit"), err)
+			}
+			log.Printf("published revert commit %x\n", revert.Created)
+
+			if !atHead {
+				log.Println("the working tree is not at the previous HEAD, run scribe pull to get the revert")
+				return nil
+			}
+			log.Printf("checkout commit %x\n", revert.Created)
+			err = r.CheckoutCommit(revert)
+			var conflict *remote.ConflictError
+			if err != nil && !errors.As(err, &conflict) {
+				return errors.Join(errors.New("failed to checkout commit"), err)
+			}
+			reportConflicts(err)
+			return err
+		})
+	},
+}
+
+func init() {
+	revertCmd.Flags().StringArrayVarP(&options.FlagMessage, "message", "m", options.FlagMessage, "Use the given value as the commit message instead of the generated one.")
+	rootCmd.AddCommand(revertCmd)
+}
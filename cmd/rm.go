@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+
+	"github.com/spf13/cobra"
+)
+
+var rmCmd = &cobra.Command{
+	Use:   "rm [--cached] <paths>...",
+	Short: "stage the deletion of files and remove them from the working tree",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkNoConflicts(); err != nil {
+			return err
+		}
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			idx, err := history.LoadIndex()
+			if err != nil {
+				return err
+			}
+
+			removed, err := r.StageRemove(idx, args, options.FlagCached)
+			for _, p := range removed {
+				log.Printf("removed %s\n", p)
+			}
+			if err != nil {
+				return errors.Join(errors.New("failed to stage deletion"), err)
+			}
+			return idx.Save()
+		})
+	},
+}
+
+func init() {
+	rmCmd.Flags().BoolVar(&options.FlagCached, "cached", false, "only stage the deletion and keep the files in the working tree")
+	rootCmd.AddCommand(rmCmd)
+}
@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"errors"
+	"log"
 	"os"
+	"scribe/internal/config"
 	"scribe/internal/options"
+	"scribe/internal/remote"
 
 	"github.com/spf13/cobra"
 )
@@ -12,9 +16,28 @@ var rootCmd = &cobra.Command{
 	Short: "SFTP based VCS",
 }
 
+// withRemote loads the local config and connects to its remote for fn.
+func withRemote(fn func(c *config.Config, r *remote.Remote) error) error {
+	log.Println("load local config")
+	c, err := config.Load()
+	if err != nil {
+		return errors.Join(errors.New("failed to load config"), err)
+	}
+
+	log.Println("connect to remote")
+	r, err := remote.Connect(c)
+	if err != nil {
+		return errors.Join(errors.New("failed to connect to remote"), err)
+	}
+	defer r.Close()
+
+	return fn(c, r)
+}
+
 func init() {
 	rootCmd.SilenceUsage = true
 	rootCmd.PersistentFlags().BoolVar(&options.FlagForce, "force", false, "enforce an illegal action, which could lead to unintentional data loss")
+	rootCmd.PersistentFlags().BoolVar(&options.FlagAllowUnverified, "allow-unverified", false, "accept commits that are unsigned or not signed by an allowed signer")
 }
 
 func Execute() {
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"scribe/internal/config"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+	"scribe/internal/remote"
+	"scribe/internal/util"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var showCmd = &cobra.Command{
+	Use:   "show <commit>[:<path>]",
+	Short: "show a commit and its changes, or the content of a file in a commit",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("Invalid count of arguments for show: %d. 1 required: show <commit>[:<path>]", len(args))
+		}
+		spec, filePath, showFile := strings.Cut(args[0], ":")
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			log.Println("pull commits from remote")
+			if err := r.PullCommits(); err != nil {
+				return errors.Join(errors.New("failed to pull commits"), err)
+			}
+
+			commit, err := r.ResolveCommit(spec)
+			if err != nil {
+				return err
+			}
+
+			if showFile {
+				return showCommitFile(r, commit, filePath)
+			}
+
+			var parent *history.Commit
+			if len(commit.Parents) != 0 {
+				if parent, err = history.Load(commit.Parents[0]); err != nil {
+					return errors.Join(errors.New("failed to load parent commit"), err)
+				}
+			}
+
+			fmt.Printf("commit %x\n", commit.Created)
+			if len(commit.Parents) != 0 {
+				ps := make([]string, len(commit.Parents))
+				for i, p := range commit.Parents {
+					ps[i] = fmt.Sprintf("%x", p)
+				}
+				fmt.Printf("Parents:   %s\n", strings.Join(ps, " "))
+			}
+			fmt.Printf("Date:      %s\n", util.FormatTime(commit.Created))
+			if len(commit.Signature) != 0 {
+				if principals, err := r.VerifyCommit(commit); err != nil {
+					fmt.Printf("Signature: invalid (%v)\n", strings.ReplaceAll(err.Error(), "\n", ": "))
+				} else if len(principals) != 0 {
+					fmt.Printf("Signed-by: %s\n", principals)
+				} else {
+					fmt.Println("Signature: not verified, no allowed signers")
+				}
+			}
+			fmt.Println()
+			for _, line := range strings.Split(strings.TrimRight(commit.Message, "\n"), "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+			fmt.Println()
+
+			emptyHash, err := c.HashReader(strings.NewReader(""))
+			if err != nil {
+				return err
+			}
+			fileSize := func(f history.CommitFile) string {
+				switch {
+				case f.Type == history.FileTypeDir:
+					return "directory"
+				case f.Size == 0 && f.Hash != emptyHash:
+					return "unknown size"
+				default:
+					return util.FormatSize(f.Size)
+				}
+			}
+
+			changes, renames := diff.DetectRenames(diff.Commits(parent, commit), parent, commit)
+			for _, d := range changes {
+				switch d.Type {
+				case diff.DiffTypeCreate:
+					f, _ := commit.File(d.Path)
+					fmt.Printf("added     %s (%s)\n", d.Path, fileSize(f))
+				case diff.DiffTypeModify:
+					pf, _ := parent.File(d.Path)
+					f, _ := commit.File(d.Path)
+					fmt.Printf("modified  %s (%s -> %s)\n", d.Path, fileSize(pf), fileSize(f))
+				case diff.DiffTypeDelete:
+					pf, _ := parent.File(d.Path)
+					fmt.Printf("deleted   %s (%s)\n", d.Path, fileSize(pf))
+				}
+			}
+			for _, rn := range renames {
+				f, _ := commit.File(rn.To)
+				fmt.Printf("renamed   %s -> %s (%s)\n", rn.From, rn.To, fileSize(f))
+			}
+
+			return nil
+		})
+	},
+}
+
+// showCommitFile prints the content of a file in the commit without
+// touching the working tree.
+func showCommitFile(r *remote.Remote, commit *history.Commit, p string) error {
+	p = strings.Trim(strings.ReplaceAll(p, "\\", "/"), "/")
+	f, ok := commit.File(p)
+	if !ok {
+		return fmt.Errorf("path %s does not exist in commit %x", p, commit.Created)
+	}
+	if f.Type == history.FileTypeDir {
+		return fmt.Errorf("path %s is an empty directory in commit %x", p, commit.Created)
+	}
+	if err := r.ReadObjectTo(f, os.Stdout); err != nil {
+		return errors.Join(fmt.Errorf("failed to read %s of commit %x", p, commit.Created), err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(showCmd)
+}
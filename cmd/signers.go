@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+	"scribe/internal/sign"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var signersCmd = &cobra.Command{
+	Use:   "signers",
+	Short: "manage the keys trusted to sign commits",
+}
+
+var signersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the allowed signers commits are verified against",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			var as sign.AllowedSigners
+			var err error
+			if options.FlagRemote {
+				as, err = r.RemoteAllowedSigners()
+			} else {
+				as, err = r.LocalAllowedSigners()
+			}
+			if err != nil {
+				return errors.Join(errors.New("failed to read allowed signers"), err)
+			}
+			for _, s := range as {
+				fmt.Printf("%s %s\n", s.Principals, ssh.FingerprintSHA256(s.Key))
+			}
+			return nil
+		})
+	},
+}
+
+var signersAddCmd = &cobra.Command{
+	Use:   "add <principals> <public key file>",
+	Short: "trust a key to sign commits and share it on the remote",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		b, err := os.ReadFile(args[1])
+		if err != nil {
+			return errors.Join(errors.New("failed to read public key"), err)
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return errors.Join(errors.New("failed to parse public key"), err)
+		}
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			as, err := r.RemoteAllowedSigners()
+			if err != nil {
+				return errors.Join(errors.New("failed to read remote allowed signers"), err)
+			}
+			if _, ok := as.Find(key); ok {
+				return fmt.Errorf("key %s is already an allowed signer", ssh.FingerprintSHA256(key))
+			}
+			as = append(as, sign.AllowedSigner{Principals: args[0], Key: key})
+
+			log.Println("write allowed signers to remote")
+			if err := r.WriteRemoteAllowedSigners(as); err != nil {
+				return err
+			}
+
+			local, err := r.LocalAllowedSigners()
+			if err != nil {
+				return errors.Join(errors.New("failed to read local allowed signers"), err)
+			}
+			if _, ok := local.Find(key); !ok {
+				local = append(local, sign.AllowedSigner{Principals: args[0], Key: key})
+			}
+			return r.SaveLocalAllowedSigners(local)
+		})
+	},
+}
+
+var signersTrustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "replace the local allowed signers with the ones on the remote",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			as, err := r.RemoteAllowedSigners()
+			if err != nil {
+				return errors.Join(errors.New("failed to read remote allowed signers"), err)
+			}
+			for _, s := range as {
+				fmt.Printf("%s %s\n", s.Principals, ssh.FingerprintSHA256(s.Key))
+			}
+			return r.SaveLocalAllowedSigners(as)
+		})
+	},
+}
+
+var signersUseCmd = &cobra.Command{
+	Use:   "use <private key file>",
+	Short: "sign your commits with an SSH ed25519 key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Println("load local config")
+		c, err := config.Load()
+		if err != nil {
+			return errors.Join(errors.New("failed to load config"), err)
+		}
+
+		kp, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		signer, err := sign.LoadSigner(kp)
+		if err != nil {
+			return err
+		}
+
+		c.SigningKey = kp
+		if err := c.Save(); err != nil {
+			return errors.Join(errors.New("failed to save config"), err)
+		}
+
+		log.Printf("commits are signed with %s\n", ssh.FingerprintSHA256(signer.PublicKey()))
+		return nil
+	},
+}
+
+// verifyHead checks the signature of the commit that is about to be checked
+// out. Pulled commits are verified already, this also covers local commits
+// and reports the signer. Unverified commits are only accepted with
+// --allow-unverified.
+func verifyHead(r *remote.Remote, head *history.Commit) error {
+	log.Printf("verify signature of commit %x\n", head.Created)
+	principals, err := r.VerifyCommit(head)
+	if err != nil {
+		if !options.FlagAllowUnverified {
+			return errors.Join(errors.New("refusing to checkout an unverified commit, use --allow-unverified to override"), err)
+		}
+		log.Printf("checkout unverified commit: %v\n", err)
+		return nil
+	}
+	if len(principals) != 0 {
+		log.Printf("commit %x is signed by %s\n", head.Created, principals)
+	}
+	return nil
+}
+
+func init() {
+	signersListCmd.Flags().BoolVar(&options.FlagRemote, "remote", false, "list the allowed signers shared on the remote")
+	signersCmd.AddCommand(signersListCmd, signersAddCmd, signersTrustCmd, signersUseCmd)
+	rootCmd.AddCommand(signersCmd)
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+	"scribe/internal/util"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var stashCmd = &cobra.Command{
+	Use:   "stash",
+	Short: "shelve local changes and reapply them later",
+}
+
+var stashPushCmd = &cobra.Command{
+	Use:   "push [-m <message>]",
+	Short: "move all local changes into a new stash and restore the checked out commit",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkNoConflicts(); err != nil {
+			return err
+		}
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			s, err := r.StashPush(strings.Join(options.FlagMessage, "\n"))
+			if err != nil {
+				return errors.Join(errors.New("failed to stash local changes"), err)
+			}
+			log.Printf("stashed %d changes on commit %x\n", len(s.Files), s.Base)
+			return nil
+		})
+	},
+}
+
+var stashPopCmd = &cobra.Command{
+	Use:   "pop [<n>]",
+	Short: "reapply a stash and drop it, the newest one by default",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkNoConflicts(); err != nil {
+			return err
+		}
+		s, err := findStash(args)
+		if err != nil {
+			return err
+		}
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			conflicts, err := r.StashApply(s)
+			for _, p := range conflicts {
+				log.Printf("conflict: %s\n", p)
+			}
+			if err != nil {
+				return errors.Join(errors.New("failed to apply stash, it was kept"), err)
+			}
+			return s.Drop()
+		})
+	},
+}
+
+var stashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the stashes, newest first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stashes, err := history.Stashes()
+		if err != nil {
+			return err
+		}
+		for i, s := range stashes {
+			subject, _, _ := strings.Cut(s.Message, "\n")
+			fmt.Printf("%d: on %x, %d changes, %s  %s\n", i, s.Base, len(s.Files), util.FormatTime(s.Created), subject)
+		}
+		return nil
+	},
+}
+
+var stashDropCmd = &cobra.Command{
+	Use:   "drop [<n>]",
+	Short: "delete a stash, the newest one by default",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := findStash(args)
+		if err != nil {
+			return err
+		}
+		if err := s.Drop(); err != nil {
+			return err
+		}
+		log.Printf("dropped stash of %d changes on commit %x\n", len(s.Files), s.Base)
+		return nil
+	},
+}
+
+// findStash returns the stash at the position given in args, as listed by
+// stash list, or the newest one.
+func findStash(args []string) (*history.Stash, error) {
+	stashes, err := history.Stashes()
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	if len(args) == 1 {
+		if n, err = strconv.Atoi(args[0]); err != nil {
+			return nil, fmt.Errorf("invalid stash %q, use the number shown by stash list", args[0])
+		}
+	}
+	if n < 0 || n >= len(stashes) {
+		return nil, fmt.Errorf("no stash %d", n)
+	}
+	return stashes[n], nil
+}
+
+func init() {
+	stashPushCmd.Flags().StringArrayVarP(&options.FlagMessage, "message", "m", options.FlagMessage, "describe the stashed changes")
+	stashCmd.AddCommand(stashPushCmd, stashPopCmd, stashListCmd, stashDropCmd)
+	rootCmd.AddCommand(stashCmd)
+}
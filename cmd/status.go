@@ -6,6 +6,8 @@ import (
 	"log"
 	"scribe/internal/config"
 	"scribe/internal/diff"
+	"scribe/internal/history"
+	"scribe/internal/util"
 
 	"github.com/spf13/cobra"
 )
@@ -26,8 +28,59 @@ var statusCmd = &cobra.Command{
 			return errors.Join(errors.New("failed to get current commit"), err)
 		}
 
+		if c.Detached {
+			fmt.Printf("detached from branch %s at %x\n", c.CurrentBranch(), currentCommit.Created)
+		} else {
+			fmt.Printf("on branch %s at %x\n", c.CurrentBranch(), currentCommit.Created)
+		}
+
+		state, ok, err := history.LoadConflictState()
+		if err != nil {
+			return err
+		}
+		if ok {
+			fmt.Printf("%s of %x stopped on conflicts, run scribe %s --continue when they are resolved\n", state.Operation, state.To, continueCommand(state.Operation))
+			fmt.Println("conflicts:")
+			for _, f := range state.Files {
+				if f.Resolved {
+					fmt.Print("r ")
+				} else {
+					fmt.Print("! ")
+				}
+				fmt.Println(f.Path)
+			}
+		}
+
+		idx, err := history.LoadIndex()
+		if err != nil {
+			return err
+		}
+		locks, err := history.LoadLocks()
+		if err != nil {
+			return err
+		}
+
+		if !idx.Empty() {
+			fmt.Println("staged:")
+			for _, e := range idx.Entries {
+				_, tracked := currentCommit.File(e.Path)
+				switch {
+				case e.Deleted:
+					fmt.Print("- ")
+				case tracked:
+					fmt.Print("~ ")
+				default:
+					fmt.Print("+ ")
+				}
+				fmt.Println(e.Path + lockedBy(c, locks, e.Path))
+			}
+			fmt.Println("unstaged:")
+		}
+
+		// unstaged changes are the ones on top of the staged state
+		staged := &history.Commit{Created: currentCommit.Created, Files: idx.Apply(currentCommit)}
 		log.Printf("diff %x to local changes\n", currentCommit.Created)
-		locallyChanged, err := diff.LocalFromCommit(c, currentCommit)
+		locallyChanged, err := diff.LocalFromCommit(c, staged)
 		if err != nil {
 			return errors.Join(errors.New("failed to diff local changes with current commit"), err)
 		}
@@ -41,13 +94,33 @@ var statusCmd = &cobra.Command{
 			case diff.DiffTypeDelete:
 				fmt.Print("- ")
 			}
-			fmt.Println(d.Path)
+			fmt.Println(d.Path + lockedBy(c, locks, d.Path))
+		}
+
+		if len(locks.Locks) != 0 {
+			fmt.Printf("locks as of %s, run scribe locks to update:\n", util.FormatTime(locks.Updated))
+			for _, l := range locks.Locks {
+				if l.Owner == c.User {
+					fmt.Print("* ")
+				} else {
+					fmt.Print("  ")
+				}
+				fmt.Printf("%s  %s on %s\n", l.Path, l.Owner, l.Host)
+			}
 		}
 
 		return nil
 	},
 }
 
+// lockedBy notes the owner of a lock of path p if it is someone else.
+func lockedBy(c *config.Config, locks *history.Locks, p string) string {
+	if l, ok := locks.Get(p); ok && l.Owner != c.User {
+		return fmt.Sprintf("  (locked by %s)", l.Owner)
+	}
+	return ""
+}
+
 func init() {
 	rootCmd.AddCommand(statusCmd)
 }
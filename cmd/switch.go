@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+
+	"github.com/spf13/cobra"
+)
+
+var switchCmd = &cobra.Command{
+	Use:   "switch [-c] <branch>",
+	Short: "check out the head of a branch and commit to it from now on",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if err := remote.CheckRefName(name); err != nil {
+			return err
+		}
+		if err := checkNoConflicts(); err != nil {
+			return err
+		}
+
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			var checkoutErr error
+			if options.FlagCreate {
+				current, err := c.CurrentCommit()
+				if err != nil {
+					return errors.Join(errors.New("failed to get current commit"), err)
+				}
+				if err := r.CreateBranch(name, current); err != nil {
+					return errors.Join(errors.New("failed to create branch"), err)
+				}
+				log.Printf("created branch %s at commit %x\n", name, current.Created)
+			} else {
+				log.Println("pull commits from remote")
+				if err := r.PullCommits(); err != nil {
+					return errors.Join(errors.New("failed to pull commits"), err)
+				}
+
+				head, err := r.GetBranchCommit(name)
+				if err != nil {
+					return err
+				}
+				if err := verifyHead(r, head); err != nil {
+					return err
+				}
+
+				log.Printf("checkout commit %x\n", head.Created)
+				checkoutErr = r.CheckoutCommit(head)
+				var conflict *remote.ConflictError
+				if checkoutErr != nil && !errors.As(checkoutErr, &conflict) {
+					return errors.Join(errors.New("failed to checkout commit"), checkoutErr)
+				}
+			}
+
+			c.Branch = name
+			if name == config.DefaultBranch {
+				c.Branch = ""
+			}
+			c.Detached = false
+			if err := c.Save(); err != nil {
+				return errors.Join(errors.New("failed to save config"), err)
+			}
+			log.Printf("switched to branch %s\n", name)
+			reportConflicts(checkoutErr)
+			return checkoutErr
+		})
+	},
+}
+
+func init() {
+	switchCmd.Flags().BoolVarP(&options.FlagCreate, "create", "c", false, "create the branch at the checked out commit before switching to it")
+	rootCmd.AddCommand(switchCmd)
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+	"scribe/internal/util"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+)
+
+var tagCmd = &cobra.Command{
+	Use:   "tag [-l] [-d] [-m <message>] [<name> [<commit>]]",
+	Short: "list, create or delete tags",
+	Long: `Tags give a commit a name that can be used anywhere a commit id is accepted.
+A new tag points to <commit>, the checked out commit by default, and can't be
+moved afterwards, only deleted.`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			switch {
+			case options.FlagDelete:
+				if len(args) != 1 {
+					return errors.New("tag -d needs exactly one tag name")
+				}
+				if !options.FlagForce {
+					t, ok, err := r.GetTag(args[0])
+					if err != nil {
+						return err
+					} else if !ok {
+						return fmt.Errorf("tag %s does not exist", args[0])
+					}
+					var del bool
+					if err := huh.NewForm(huh.NewGroup(
+						huh.NewConfirm().
+							Title(fmt.Sprintf("delete tag %s of commit %x?", t.Name, t.Commit)).
+							Description("Others may rely on it, a new tag with the same name can point somewhere else.").
+							Affirmative("Delete").
+							Negative("Cancel").
+							Value(&del),
+					)).Run(); err != nil {
+						return errors.Join(errors.New("failed to confirm, use --force to delete the tag anyway"), err)
+					}
+					if !del {
+						return nil
+					}
+				}
+				if err := r.DeleteTag(args[0]); err != nil {
+					return err
+				}
+				log.Printf("deleted tag %s\n", args[0])
+				return nil
+
+			case options.FlagList || len(args) == 0:
+				tags, err := r.Tags()
+				if err != nil {
+					return err
+				}
+				for _, t := range tags {
+					subject, _, _ := strings.Cut(t.Message, "\n")
+					fmt.Printf("%s\t%x\t%s\t%s\t%s\n", t.Name, t.Commit, util.FormatTime(t.Created), t.Author, subject)
+				}
+				return nil
+			}
+
+			var target *history.Commit
+			var err error
+			if len(args) == 2 {
+				log.Println("pull commits from remote")
+				if err := r.PullCommits(); err != nil {
+					return errors.Join(errors.New("failed to pull commits"), err)
+				}
+				target, err = r.ResolveCommit(args[1])
+			} else {
+				target, err = c.CurrentCommit()
+			}
+			if err != nil {
+				return err
+			}
+
+			t := &remote.Tag{
+				Name:    args[0],
+				Commit:  target.Created,
+				Message: strings.Join(options.FlagMessage, "\n"),
+				Author:  c.User,
+			}
+			if err := r.CreateTag(t); err != nil {
+				return errors.Join(errors.New("failed to create tag"), err)
+			}
+			log.Printf("tagged commit %x as %s\n", target.Created, t.Name)
+			return nil
+		})
+	},
+}
+
+func init() {
+	tagCmd.Flags().BoolVarP(&options.FlagList, "list", "l", false, "list the tags")
+	tagCmd.Flags().BoolVarP(&options.FlagDelete, "delete", "d", false, "delete the tag, the commit stays")
+	tagCmd.Flags().StringArrayVarP(&options.FlagMessage, "message", "m", options.FlagMessage, "annotate the tag with a message")
+	rootCmd.AddCommand(tagCmd)
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"scribe/internal/config"
+	"scribe/internal/options"
+	"scribe/internal/remote"
+
+	"github.com/spf13/cobra"
+)
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock (<paths>...|--force)",
+	Short: "unlock files, or break a stale remote lock",
+	Long: `With paths the locks of those files are removed, --force also removes
+locks of others.
+
+Without paths the remote lock is shown. Commits and other ref updates hold
+it for a short time. A client that crashed while holding it blocks everyone
+else until the lock expires, --force removes it right away.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withRemote(func(c *config.Config, r *remote.Remote) error {
+			if len(args) != 0 {
+				unlocked, err := r.UnlockPaths(args, options.FlagForce)
+				if err != nil {
+					return errors.Join(errors.New("failed to unlock files"), err)
+				}
+				for _, p := range unlocked {
+					log.Printf("unlocked %s\n", p)
+				}
+				return nil
+			}
+
+			l, ok, err := r.ReadLock()
+			if err != nil {
+				return errors.Join(errors.New("failed to read remote lock"), err)
+			}
+			if !ok {
+				log.Println("remote is not locked")
+				return nil
+			}
+			if !options.FlagForce {
+				return fmt.Errorf("remote is locked by %s, use --force to break the lock", l)
+			}
+
+			if l, ok, err = r.BreakLock(); err != nil {
+				return errors.Join(errors.New("failed to break remote lock"), err)
+			} else if ok {
+				log.Printf("removed the lock of %s\n", l)
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unlockCmd)
+}
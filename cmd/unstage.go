@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+
+	"github.com/spf13/cobra"
+)
+
+var unstageCmd = &cobra.Command{
+	Use:   "unstage <paths>...",
+	Short: "drop staged changes from the index, the working tree is left alone",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		idx, err := history.LoadIndex()
+		if err != nil {
+			return err
+		}
+
+		dropped := idx.Unstage(func(p string) bool {
+			return diff.MatchPath(p, args)
+		})
+		if len(dropped) == 0 {
+			return fmt.Errorf("no staged changes match %v", args)
+		}
+		for _, p := range dropped {
+			log.Printf("unstaged %s\n", p)
+		}
+		return idx.Save()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unstageCmd)
+}
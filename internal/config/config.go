@@ -3,8 +3,11 @@ package config
 import (
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path/filepath"
+	"scribe/internal/crypt"
 	"scribe/internal/history"
 	"scribe/internal/util"
 
@@ -19,6 +22,10 @@ const (
 
 const Version = 1
 
+// DefaultBranch is the branch a repository starts with, it is stored in the
+// remote HEAD file.
+const DefaultBranch = "main"
+
 const DefaultIgnore = `.DS_Store
 .vs/
 .idea/
@@ -36,8 +43,20 @@ type Config struct {
 	Password string `yaml:"-"`
 	Path     string `yaml:"path"`
 	Commit   int64  `yaml:"commit"`
+	// Branch is the checked out branch, empty for the default branch.
+	Branch string `yaml:"branch,omitempty"`
+	// Detached is set when the checked out commit is not the remote HEAD
+	// it was checked out from.
+	Detached bool   `yaml:"detached,omitempty"`
 	Ignore   string `yaml:"ignore"`
-	Location string `yaml:"-"`
+	// Identity is the path of the private key used to unlock encrypted
+	// repositories the key was shared with.
+	Identity string `yaml:"identity,omitempty"`
+	// SigningKey is the path of the SSH ed25519 private key commits are
+	// signed with.
+	SigningKey string     `yaml:"signing_key,omitempty"`
+	Key        *crypt.Key `yaml:"-"`
+	Location   string     `yaml:"-"`
 }
 
 func findConfigFile() (string, error) {
@@ -59,10 +78,84 @@ func findConfigFile() (string, error) {
 	return "", errors.New("no " + ConfigFileName + " found")
 }
 
+// CurrentBranch returns the name of the checked out branch.
+func (c *Config) CurrentBranch() string {
+	if c.Branch == "" {
+		return DefaultBranch
+	}
+	return c.Branch
+}
+
 func (c *Config) FullUser() string {
 	return fmt.Sprintf("%s@%s:%d", c.User, c.Host, c.Port)
 }
 
+// keyringKeyUser is the keyring entry of the repository key.
+func (c *Config) keyringKeyUser() string {
+	return c.FullUser() + "#" + c.Path
+}
+
+// HashReader hashes file content the way the repository does, with a keyed
+// hash for encrypted repositories.
+func (c *Config) HashReader(r io.Reader) (string, error) {
+	h := c.NewHash()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return util.EncodeHash(h), nil
+}
+
+func (c *Config) NewHash() hash.Hash {
+	if c.Key != nil {
+		return c.Key.NewHash()
+	}
+	return util.NewHash()
+}
+
+// SaveKey stores the repository key in the keyring.
+func (c *Config) SaveKey() error {
+	if c.Key == nil {
+		return nil
+	}
+	if err := keyring.Set(
+		KeyringService,
+		c.keyringKeyUser(),
+		c.Key.String(),
+	); err != nil {
+		return errors.Join(errors.New("failed to set keyring repository key"), err)
+	}
+	return nil
+}
+
+// IdentityPath is the private key file used to unlock encrypted
+// repositories, the configured one or the default in the user config dir.
+func (c *Config) IdentityPath() (string, error) {
+	if len(c.Identity) != 0 {
+		return c.Identity, nil
+	}
+	return DefaultIdentityPath()
+}
+
+func DefaultIdentityPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "scribe", "identity"), nil
+}
+
+func (c *Config) saveKeyring() error {
+	if err := keyring.Set(
+		KeyringService,
+		c.FullUser(),
+		c.Password,
+	); err != nil {
+		return errors.Join(errors.New("failed to set keyring credentials"), err)
+	}
+
+	return c.SaveKey()
+}
+
 func (c *Config) SaveNew() error {
 	var f *os.File
 	{
@@ -81,15 +174,7 @@ func (c *Config) SaveNew() error {
 		return errors.Join(errors.New("failed to yaml encode into file "+ConfigFileName), err)
 	}
 
-	if err := keyring.Set(
-		KeyringService,
-		c.FullUser(),
-		c.Password,
-	); err != nil {
-		return errors.Join(errors.New("failed to set keyring credentials"), err)
-	}
-
-	return nil
+	return c.saveKeyring()
 }
 
 func (c *Config) Save() error {
@@ -104,15 +189,7 @@ func (c *Config) Save() error {
 		return errors.Join(errors.New("failed to yaml encode into file "+ConfigFileName), err)
 	}
 
-	if err := keyring.Set(
-		KeyringService,
-		c.FullUser(),
-		c.Password,
-	); err != nil {
-		return errors.Join(errors.New("failed to set keyring credentials"), err)
-	}
-
-	return nil
+	return c.saveKeyring()
 }
 
 func RepoRoot() (string, error) {
@@ -149,6 +226,14 @@ func Load() (*Config, error) {
 		}
 	}
 
+	if k, err := keyring.Get(KeyringService, c.keyringKeyUser()); err == nil {
+		if c.Key, err = crypt.ParseKey(k); err != nil {
+			return nil, errors.Join(errors.New("failed to parse keyring repository key"), err)
+		}
+	} else if !errors.Is(err, keyring.ErrNotFound) {
+		return nil, errors.Join(errors.New("failed to get keyring repository key"), err)
+	}
+
 	return c, nil
 }
 
@@ -156,17 +241,5 @@ func (c *Config) CurrentCommit() (*history.Commit, error) {
 	if c.Commit == 0 {
 		return nil, errors.New("no commit checked out")
 	}
-	f, err := os.Open(filepath.Join(filepath.Dir(c.Location), ".scribe", fmt.Sprintf("%x.yaml", c.Commit)))
-	if err != nil {
-		return nil, errors.Join(fmt.Errorf("failed to open commit file for commit %x", c.Commit), err)
-	}
-	defer f.Close()
-
-	yd := yaml.NewDecoder(f)
-	commit := &history.Commit{}
-	if err := yd.Decode(commit); err != nil {
-		return nil, errors.Join(errors.New("failed to decode commit file"), err)
-	}
-
-	return commit, nil
+	return history.Load(c.Commit)
 }
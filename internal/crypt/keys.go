@@ -0,0 +1,270 @@
+package crypt
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key is the repository key. Everything is encrypted with keys derived from
+// it, and it is stored on the remote wrapped once per passphrase or recipient.
+type Key struct {
+	master []byte
+}
+
+type (
+	// Stanza is the repository key wrapped for a single passphrase or recipient.
+	Stanza struct {
+		Type string `yaml:"type"`
+		// Salt is the scrypt salt of passphrase stanzas.
+		Salt string `yaml:"salt,omitempty"`
+		// Recipient and Ephemeral are the public keys of recipient stanzas.
+		Recipient string `yaml:"recipient,omitempty"`
+		Ephemeral string `yaml:"ephemeral,omitempty"`
+		Body      string `yaml:"body"`
+	}
+
+	// Identity is a private key that can unwrap recipient stanzas.
+	Identity struct {
+		key *ecdh.PrivateKey
+	}
+)
+
+const (
+	StanzaTypePassphrase = "scrypt"
+	StanzaTypeRecipient  = "x25519"
+
+	identityPrefix  = "SCRIBE-SECRET-KEY-"
+	recipientPrefix = "scribe-"
+	keySize         = 32
+)
+
+var b64 = base64.RawURLEncoding
+
+func NewKey() (*Key, error) {
+	master := make([]byte, keySize)
+	if _, err := rand.Read(master); err != nil {
+		return nil, err
+	}
+	return &Key{master: master}, nil
+}
+
+// ParseKey decodes a key encoded with String.
+func ParseKey(s string) (*Key, error) {
+	master, err := b64.DecodeString(s)
+	if err != nil || len(master) != keySize {
+		return nil, errors.New("invalid repository key")
+	}
+	return &Key{master: master}, nil
+}
+
+func (k *Key) String() string {
+	return b64.EncodeToString(k.master)
+}
+
+func (k *Key) derive(info string) []byte {
+	d, err := hkdf.Key(sha256.New, k.master, nil, info, keySize)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (k *Key) encryption() []byte {
+	return k.derive("scribe encryption")
+}
+
+// NewHash returns a keyed hash for object content. Equal content still has
+// equal hashes, so deduplication works, but the remote can't tell what it is.
+func (k *Key) NewHash() hash.Hash {
+	return hmac.New(sha256.New, k.derive("scribe hash"))
+}
+
+func wrap(kek []byte, master []byte) (string, error) {
+	aead, err := chacha20poly1305.New(kek)
+	if err != nil {
+		return "", err
+	}
+	// every wrapping key is used exactly once, a zero nonce is fine
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return b64.EncodeToString(aead.Seal(nil, nonce, master, nil)), nil
+}
+
+func unwrap(kek []byte, body string) (*Key, error) {
+	aead, err := chacha20poly1305.New(kek)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := b64.DecodeString(body)
+	if err != nil {
+		return nil, errors.New("invalid stanza body")
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	master, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{master: master}, nil
+}
+
+func passphraseKEK(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, keySize)
+}
+
+// WrapPassphrase wraps the key with a key derived from the passphrase.
+func (k *Key) WrapPassphrase(passphrase string) (Stanza, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return Stanza{}, err
+	}
+	kek, err := passphraseKEK(passphrase, salt)
+	if err != nil {
+		return Stanza{}, err
+	}
+	body, err := wrap(kek, k.master)
+	if err != nil {
+		return Stanza{}, err
+	}
+	return Stanza{Type: StanzaTypePassphrase, Salt: b64.EncodeToString(salt), Body: body}, nil
+}
+
+func recipientKEK(shared []byte, ephemeral []byte, recipient []byte) ([]byte, error) {
+	return hkdf.Key(sha256.New, shared, append(append([]byte{}, ephemeral...), recipient...), "scribe key wrap", keySize)
+}
+
+// WrapRecipient wraps the key for the owner of the recipient public key.
+func (k *Key) WrapRecipient(recipient string) (Stanza, error) {
+	pub, err := ParseRecipient(recipient)
+	if err != nil {
+		return Stanza{}, err
+	}
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Stanza{}, err
+	}
+	shared, err := eph.ECDH(pub)
+	if err != nil {
+		return Stanza{}, err
+	}
+	kek, err := recipientKEK(shared, eph.PublicKey().Bytes(), pub.Bytes())
+	if err != nil {
+		return Stanza{}, err
+	}
+	body, err := wrap(kek, k.master)
+	if err != nil {
+		return Stanza{}, err
+	}
+	return Stanza{
+		Type:      StanzaTypeRecipient,
+		Recipient: recipient,
+		Ephemeral: b64.EncodeToString(eph.PublicKey().Bytes()),
+		Body:      body,
+	}, nil
+}
+
+// UnwrapPassphrase tries the passphrase on every passphrase stanza.
+func UnwrapPassphrase(stanzas []Stanza, passphrase string) (*Key, error) {
+	for _, s := range stanzas {
+		if s.Type != StanzaTypePassphrase {
+			continue
+		}
+		salt, err := b64.DecodeString(s.Salt)
+		if err != nil {
+			continue
+		}
+		kek, err := passphraseKEK(passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+		if k, err := unwrap(kek, s.Body); err == nil {
+			return k, nil
+		}
+	}
+	return nil, errors.New("wrong passphrase")
+}
+
+// Unwrap tries the identity on every recipient stanza addressed to it.
+func (id *Identity) Unwrap(stanzas []Stanza) (*Key, error) {
+	recipient := id.Recipient()
+	for _, s := range stanzas {
+		if s.Type != StanzaTypeRecipient || s.Recipient != recipient {
+			continue
+		}
+		ephBytes, err := b64.DecodeString(s.Ephemeral)
+		if err != nil {
+			continue
+		}
+		eph, err := ecdh.X25519().NewPublicKey(ephBytes)
+		if err != nil {
+			continue
+		}
+		shared, err := id.key.ECDH(eph)
+		if err != nil {
+			continue
+		}
+		kek, err := recipientKEK(shared, ephBytes, id.key.PublicKey().Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if k, err := unwrap(kek, s.Body); err == nil {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("repository key is not shared with %s", recipient)
+}
+
+func NewIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+func ParseIdentity(s string) (*Identity, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, identityPrefix) {
+		return nil, errors.New("invalid identity")
+	}
+	b, err := b64.DecodeString(strings.TrimPrefix(s, identityPrefix))
+	if err != nil {
+		return nil, errors.New("invalid identity")
+	}
+	key, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid identity"), err)
+	}
+	return &Identity{key: key}, nil
+}
+
+func (id *Identity) String() string {
+	return identityPrefix + b64.EncodeToString(id.key.Bytes())
+}
+
+// Recipient is the public key others use to share a repository key with
+// the owner of this identity.
+func (id *Identity) Recipient() string {
+	return recipientPrefix + b64.EncodeToString(id.key.PublicKey().Bytes())
+}
+
+func ParseRecipient(s string) (*ecdh.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, recipientPrefix) {
+		return nil, fmt.Errorf("invalid recipient %q", s)
+	}
+	b, err := b64.DecodeString(strings.TrimPrefix(s, recipientPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q", s)
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
@@ -0,0 +1,119 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	key := newTestKey(t)
+	parsed, err := ParseKey(key.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.master, key.master) {
+		t.Fatal("parsed key differs")
+	}
+	if _, err := ParseKey("too-short"); err == nil {
+		t.Fatal("invalid key was accepted")
+	}
+}
+
+func TestWrapPassphrase(t *testing.T) {
+	key := newTestKey(t)
+	s, err := key.WrapPassphrase("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Type != StanzaTypePassphrase {
+		t.Fatalf("stanza type %q", s.Type)
+	}
+
+	got, err := UnwrapPassphrase([]Stanza{s}, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.master, key.master) {
+		t.Fatal("unwrapped key differs")
+	}
+	if _, err := UnwrapPassphrase([]Stanza{s}, "wrong"); err == nil {
+		t.Fatal("wrong passphrase unwrapped the key")
+	}
+
+	s.Body = s.Body[:len(s.Body)-2] + "AA"
+	if _, err := UnwrapPassphrase([]Stanza{s}, "correct horse"); err == nil {
+		t.Fatal("modified stanza was accepted")
+	}
+}
+
+func TestWrapRecipient(t *testing.T) {
+	key := newTestKey(t)
+	id, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := key.WrapRecipient(id.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := id.Unwrap([]Stanza{s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.master, key.master) {
+		t.Fatal("unwrapped key differs")
+	}
+	if _, err := other.Unwrap([]Stanza{s}); err == nil {
+		t.Fatal("another identity unwrapped the key")
+	}
+
+	// a stanza that claims our recipient but was wrapped for someone else
+	forged, err := key.WrapRecipient(other.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Recipient = id.Recipient()
+	if _, err := id.Unwrap([]Stanza{forged}); err == nil {
+		t.Fatal("stanza for another recipient was accepted")
+	}
+}
+
+func TestIdentityRoundTrip(t *testing.T) {
+	id, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseIdentity(id.String() + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Recipient() != id.Recipient() {
+		t.Fatal("parsed identity has another recipient")
+	}
+	if _, err := ParseRecipient(id.Recipient()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseIdentity("SCRIBE-SECRET-KEY-invalid"); err == nil {
+		t.Fatal("invalid identity was accepted")
+	}
+}
+
+func TestKeyedHash(t *testing.T) {
+	a, b := newTestKey(t), newTestKey(t)
+	sum := func(k *Key) []byte {
+		h := k.NewHash()
+		h.Write([]byte("content"))
+		return h.Sum(nil)
+	}
+	if !bytes.Equal(sum(a), sum(a)) {
+		t.Fatal("keyed hash is not deterministic")
+	}
+	if bytes.Equal(sum(a), sum(b)) {
+		t.Fatal("different keys gave the same hash")
+	}
+}
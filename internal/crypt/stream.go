@@ -0,0 +1,164 @@
+package crypt
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Files are encrypted in chunks, so large objects never have to be held in
+// memory. Every file has its own random salt from which the payload key is
+// derived. The chunk nonce is a counter with a flag for the last chunk, which
+// prevents reordering and truncation.
+const (
+	chunkSize = 64 * 1024
+	saltSize  = 16
+)
+
+func payloadAEAD(key []byte, salt []byte) (cipher.AEAD, error) {
+	k, err := hkdf.Key(sha256.New, key, salt, "scribe payload", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(k)
+}
+
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type writer struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+// NewWriter returns a writer encrypting everything written to it into dst.
+// Close must be called to write the last chunk, it does not close dst.
+func NewWriter(key *Key, dst io.Writer) (io.WriteCloser, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := payloadAEAD(key.encryption(), salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dst.Write(salt); err != nil {
+		return nil, err
+	}
+	return &writer{dst: dst, aead: aead, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encryption writer")
+	}
+	n := 0
+	for len(p) != 0 {
+		// a full chunk is only sealed once more data follows, the last
+		// chunk has to be marked as such
+		if len(w.buf) == chunkSize {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (w *writer) seal(last bool) error {
+	out := w.aead.Seal(nil, chunkNonce(w.counter, last), w.buf, nil)
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.dst.Write(out)
+	return err
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+type reader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	out     []byte
+	counter uint64
+	done    bool
+}
+
+// NewReader returns a reader decrypting src. Reading fails if the content
+// was modified or truncated.
+func NewReader(key *Key, src io.Reader) (io.Reader, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(src, salt); err != nil {
+		return nil, errors.Join(errors.New("failed to read encryption header"), err)
+	}
+	aead, err := payloadAEAD(key.encryption(), salt)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{
+		src:  bufio.NewReaderSize(src, chunkSize+chacha20poly1305.Overhead),
+		aead: aead,
+		buf:  make([]byte, chunkSize+chacha20poly1305.Overhead),
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *reader) open() error {
+	n, err := io.ReadFull(r.src, r.buf)
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		r.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err := r.src.Peek(1); err == io.EOF {
+			r.done = true
+		}
+	}
+	out, err := r.aead.Open(r.buf[:0], chunkNonce(r.counter, r.done), r.buf[:n], nil)
+	if err != nil {
+		return errors.New("failed to decrypt, the data was modified or encrypted with another key")
+	}
+	r.counter++
+	r.out = out
+	return nil
+}
@@ -0,0 +1,91 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func encrypt(t *testing.T, key *Key, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(key, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(key *Key, sealed []byte) ([]byte, error) {
+	r, err := NewReader(key, bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func newTestKey(t *testing.T) *Key {
+	t.Helper()
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := newTestKey(t)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		plain := make([]byte, size)
+		if _, err := rand.Read(plain); err != nil {
+			t.Fatal(err)
+		}
+		got, err := decrypt(key, encrypt(t, key, plain))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: decrypted content differs", size)
+		}
+	}
+}
+
+func TestStreamSaltsDiffer(t *testing.T) {
+	key := newTestKey(t)
+	plain := []byte("same content")
+	if bytes.Equal(encrypt(t, key, plain), encrypt(t, key, plain)) {
+		t.Fatal("encrypting twice gave the same ciphertext")
+	}
+}
+
+func TestStreamTampered(t *testing.T) {
+	key := newTestKey(t)
+	sealed := encrypt(t, key, bytes.Repeat([]byte("x"), 2*chunkSize+10))
+
+	flipped := bytes.Clone(sealed)
+	flipped[saltSize+5] ^= 1
+	if _, err := decrypt(key, flipped); err == nil {
+		t.Fatal("modified ciphertext was accepted")
+	}
+
+	// dropping the last chunk leaves a chunk that is not marked as last
+	chunk := chunkSize + 16
+	if _, err := decrypt(key, sealed[:saltSize+2*chunk]); err == nil {
+		t.Fatal("truncated ciphertext was accepted")
+	}
+
+	if _, err := decrypt(key, sealed[:saltSize-1]); err == nil {
+		t.Fatal("ciphertext without header was accepted")
+	}
+
+	if _, err := decrypt(newTestKey(t), sealed); err == nil {
+		t.Fatal("ciphertext was decrypted with another key")
+	}
+}
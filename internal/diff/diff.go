@@ -7,10 +7,11 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"scribe/internal/config"
 	"scribe/internal/history"
 	"scribe/internal/ignore"
-	"scribe/internal/util"
+	"slices"
 	"strings"
 )
 
@@ -35,10 +36,10 @@ func LocalFromCommit(conf *config.Config, c *history.Commit) (DiffList, error) {
 	var diff DiffList
 
 	for _, cf := range c.Files {
-		lfp := filepath.Join(root, cf.Path)
+		lfp := filepath.Join(root, filepath.FromSlash(cf.Path))
 
 		// does file exist?
-		fi, err := os.Stat(lfp)
+		fi, err := os.Lstat(lfp)
 		if err != nil {
 			if os.IsNotExist(err) {
 				diff = append(diff, Diff{cf.Path, DiffTypeDelete})
@@ -46,57 +47,27 @@ func LocalFromCommit(conf *config.Config, c *history.Commit) (DiffList, error) {
 			}
 			return nil, errors.Join(errors.New("failed to read file"), err)
 		}
-		if fi.IsDir() {
-			diff = append(diff, Diff{cf.Path, DiffTypeDelete})
-			continue
-		}
 
 		// has file changed?
-		f, err := os.Open(lfp)
+		local, err := Stat(conf, lfp, cf.Path, fi)
 		if err != nil {
-			return nil, errors.Join(errors.New("failed to open file"), err)
+			return nil, err
 		}
-		defer f.Close()
-		h, err := util.HashReader(f)
-		if err != nil {
-			return nil, errors.Join(fmt.Errorf("failed to hash file %s", lfp), err)
+		if local.Type == history.FileTypeDir && cf.Type != history.FileTypeDir {
+			diff = append(diff, Diff{cf.Path, DiffTypeDelete})
+			continue
 		}
-		if h != cf.Hash {
+		if !sameLocal(local, cf) {
 			diff = append(diff, Diff{cf.Path, DiffTypeModify})
 		}
 	}
 
-	m := ignore.GetMatcher(conf)
-	if err := filepath.WalkDir(root, func(absPath string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		repoPath, err := filepath.Rel(root, absPath)
-		if err != nil {
-			return errors.Join(errors.New("failed to get relative path"), err)
-		}
-		gitPath := util.TrimSliceEmptyString(strings.Split(repoPath, string(filepath.Separator)))
-		isDir := d.IsDir()
-		if m.Match(gitPath, isDir) {
-			// excluded from ignore
-			if isDir {
-				return filepath.SkipDir
-			} else {
-				return nil
-			}
-		}
-		if isDir {
+	if err := ignore.Walk(conf, root, func(repoPath string, absPath string, d fs.DirEntry) error {
+		if _, ok := c.File(repoPath); ok {
 			return nil
 		}
-		repoPosixPath := path.Clean(strings.Join(gitPath, "/"))
-		for _, cf := range c.Files {
-			if cf.Path == repoPosixPath {
-				return nil
-			}
-		}
 
-		diff = append(diff, Diff{repoPosixPath, DiffTypeCreate})
+		diff = append(diff, Diff{repoPath, DiffTypeCreate})
 		return nil
 	}); err != nil {
 		return nil, errors.Join(fmt.Errorf("error while walking dir %s", root), err)
@@ -105,10 +76,67 @@ func LocalFromCommit(conf *config.Config, c *history.Commit) (DiffList, error) {
 	return diff, nil
 }
 
+// Stat describes the local file at absPath as a commit file.
+// Directories are described without looking at their content.
+func Stat(conf *config.Config, absPath string, repoPath string, fi fs.FileInfo) (history.CommitFile, error) {
+	cf := history.CommitFile{Path: repoPath}
+	switch {
+	case fi.IsDir():
+		cf.Type = history.FileTypeDir
+	case fi.Mode()&fs.ModeSymlink != 0:
+		cf.Type = history.FileTypeSymlink
+		target, err := os.Readlink(absPath)
+		if err != nil {
+			return cf, errors.Join(fmt.Errorf("failed to read symlink %s", absPath), err)
+		}
+		cf.Size = int64(len(target))
+		if cf.Hash, err = conf.HashReader(strings.NewReader(target)); err != nil {
+			return cf, errors.Join(fmt.Errorf("failed to hash symlink %s", absPath), err)
+		}
+	default:
+		cf.Mode = fi.Mode().Perm()
+		cf.Size = fi.Size()
+		f, err := os.Open(absPath)
+		if err != nil {
+			return cf, errors.Join(errors.New("failed to open file"), err)
+		}
+		defer f.Close()
+		if cf.Hash, err = conf.HashReader(f); err != nil {
+			return cf, errors.Join(fmt.Errorf("failed to hash file %s", absPath), err)
+		}
+	}
+	return cf, nil
+}
+
+// LocalSame reports whether the local file at absPath matches the commit
+// file. A missing local file never matches.
+func LocalSame(conf *config.Config, absPath string, cf history.CommitFile) (bool, error) {
+	fi, err := os.Lstat(absPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Join(fmt.Errorf("failed to stat %s", absPath), err)
+	}
+	local, err := Stat(conf, absPath, cf.Path, fi)
+	if err != nil {
+		return false, err
+	}
+	return sameLocal(local, cf), nil
+}
+
+// sameLocal compares a local file to a commit file. Windows does not know
+// executable bits, so they are only compared elsewhere.
+func sameLocal(local history.CommitFile, cf history.CommitFile) bool {
+	if runtime.GOOS == "windows" {
+		return local.Type == cf.Type && local.Hash == cf.Hash
+	}
+	return local.Same(cf)
+}
+
 func (dl DiffList) HasDelete(path string) bool {
 	for _, d := range dl {
-		if d.Type == DiffTypeDelete {
-			return d.Path == path
+		if d.Type == DiffTypeDelete && d.Path == path {
+			return true
 		}
 	}
 	return false
@@ -116,8 +144,8 @@ func (dl DiffList) HasDelete(path string) bool {
 
 func (dl DiffList) HasModify(path string) bool {
 	for _, d := range dl {
-		if d.Type == DiffTypeModify {
-			return d.Path == path
+		if d.Type == DiffTypeModify && d.Path == path {
+			return true
 		}
 	}
 	return false
@@ -125,8 +153,8 @@ func (dl DiffList) HasModify(path string) bool {
 
 func (dl DiffList) HasModifyOrDelete(path string) bool {
 	for _, d := range dl {
-		if d.Type == DiffTypeModify || d.Type == DiffTypeDelete {
-			return d.Path == path
+		if (d.Type == DiffTypeModify || d.Type == DiffTypeDelete) && d.Path == path {
+			return true
 		}
 	}
 	return false
@@ -134,9 +162,112 @@ func (dl DiffList) HasModifyOrDelete(path string) bool {
 
 func (dl DiffList) HasCreate(path string) bool {
 	for _, d := range dl {
-		if d.Type == DiffTypeCreate {
-			return d.Path == path
+		if d.Type == DiffTypeCreate && d.Path == path {
+			return true
 		}
 	}
 	return false
 }
+
+// Commits lists the changes from one commit to another, sorted by path.
+// from is nil for the root commit. Both commits need their files loaded.
+func Commits(from *history.Commit, to *history.Commit) DiffList {
+	var diff DiffList
+	for _, f := range to.Files {
+		if from == nil {
+			diff = append(diff, Diff{f.Path, DiffTypeCreate})
+			continue
+		}
+		if ff, ok := from.File(f.Path); !ok {
+			diff = append(diff, Diff{f.Path, DiffTypeCreate})
+		} else if !ff.Same(f) {
+			diff = append(diff, Diff{f.Path, DiffTypeModify})
+		}
+	}
+	if from != nil {
+		for _, f := range from.Files {
+			if _, ok := to.File(f.Path); !ok {
+				diff = append(diff, Diff{f.Path, DiffTypeDelete})
+			}
+		}
+	}
+	slices.SortFunc(diff, func(a, b Diff) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return diff
+}
+
+// MatchPath reports whether p is selected by any of the patterns. A pattern
+// selects the path itself, everything below it if it is a directory, or
+// the paths matching it as a glob.
+func MatchPath(p string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(path.Clean(filepath.ToSlash(pattern)), "/")
+		if pattern == "." || p == pattern || strings.HasPrefix(p, pattern+"/") {
+			return true
+		}
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the changes to paths selected by the patterns.
+func (dl DiffList) Filter(patterns []string) DiffList {
+	var out DiffList
+	for _, d := range dl {
+		if MatchPath(d.Path, patterns) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+type Rename struct {
+	From string
+	To   string
+}
+
+// DetectRenames pairs deleted and created paths with the same content as
+// renames and removes them from the list.
+func DetectRenames(dl DiffList, from *history.Commit, to *history.Commit) (DiffList, []Rename) {
+	if from == nil {
+		return dl, nil
+	}
+
+	deleted := make(map[string][]string)
+	for _, d := range dl {
+		if d.Type != DiffTypeDelete {
+			continue
+		}
+		if f, ok := from.File(d.Path); ok && len(f.Hash) != 0 {
+			deleted[f.Hash] = append(deleted[f.Hash], d.Path)
+		}
+	}
+
+	var renames []Rename
+	renamed := make(map[string]struct{})
+	for _, d := range dl {
+		if d.Type != DiffTypeCreate {
+			continue
+		}
+		f, ok := to.File(d.Path)
+		if !ok || len(deleted[f.Hash]) == 0 {
+			continue
+		}
+		src := deleted[f.Hash][0]
+		deleted[f.Hash] = deleted[f.Hash][1:]
+		renames = append(renames, Rename{From: src, To: d.Path})
+		renamed[src] = struct{}{}
+		renamed[d.Path] = struct{}{}
+	}
+
+	out := make(DiffList, 0, len(dl)-len(renamed))
+	for _, d := range dl {
+		if _, ok := renamed[d.Path]; !ok || d.Type == DiffTypeModify {
+			out = append(out, d)
+		}
+	}
+	return out, renames
+}
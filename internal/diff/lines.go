@@ -0,0 +1,229 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Change replaces Del lines of a starting at A with Ins lines of b
+// starting at B.
+type Change struct {
+	A, Del int
+	B, Ins int
+}
+
+// maxEditDistance bounds the work of the line diff. Files that differ in
+// more lines are treated as replaced entirely.
+const maxEditDistance = 4096
+
+// SplitLines splits text into lines, keeping the line endings.
+func SplitLines(text string) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// IsBinary guesses whether content is binary: it contains a NUL byte or
+// is not valid UTF-8 near its start.
+func IsBinary(content []byte) bool {
+	head := content
+	if len(head) > 8000 {
+		head = head[:8000]
+		// don't cut a multi byte rune in half
+		for i := 0; i < utf8.UTFMax && !utf8.Valid(head); i++ {
+			head = head[:len(head)-1]
+		}
+	}
+	return bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(head)
+}
+
+// Lines computes the changes turning a into b with the Myers algorithm.
+func Lines(a, b []string) []Change {
+	// common prefix and suffix are cheap to strip and the usual case
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	changes := myers(a[pre:len(a)-suf], b[pre:len(b)-suf])
+	for i := range changes {
+		changes[i].A += pre
+		changes[i].B += pre
+	}
+	return changes
+}
+
+func myers(a, b []string) []Change {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	if n == 0 || m == 0 {
+		return []Change{{A: 0, Del: n, B: 0, Ins: m}}
+	}
+
+	max := n + m
+	if max > maxEditDistance {
+		max = maxEditDistance
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] keeps the diagonals -(d-1)..d-1 of v before step d, which
+	// is all the walk back reads, so the trace grows with d² and not d·max
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		if d == 0 {
+			trace = append(trace, nil)
+		} else {
+			trace = append(trace, slices.Clone(v[offset-d+1:offset+d]))
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return []Change{{A: 0, Del: n, B: 0, Ins: m}}
+	}
+
+	// walk the trace back to collect the single line edits
+	type edit struct {
+		del  bool
+		a, b int
+	}
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v, offset := trace[d], d-1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+		}
+		if x == prevX {
+			edits = append(edits, edit{del: false, a: x, b: prevY})
+		} else {
+			edits = append(edits, edit{del: true, a: prevX, b: y})
+		}
+		x, y = prevX, prevY
+	}
+
+	// edits are collected back to front, merge adjacent ones into changes
+	var changes []Change
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		if l := len(changes) - 1; l >= 0 && changes[l].A+changes[l].Del == e.a && changes[l].B+changes[l].Ins == e.b {
+			if e.del {
+				changes[l].Del++
+			} else {
+				changes[l].Ins++
+			}
+			continue
+		}
+		c := Change{A: e.a, B: e.b}
+		if e.del {
+			c.Del = 1
+		} else {
+			c.Ins = 1
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// Unified formats the changes turning a into b as a unified diff with the
+// given number of context lines.
+func Unified(aName, bName string, a, b []string, context int) string {
+	changes := Lines(a, b)
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+
+	for i := 0; i < len(changes); {
+		// group changes whose context overlaps into one hunk
+		j := i + 1
+		for j < len(changes) && changes[j].A-(changes[j-1].A+changes[j-1].Del) <= 2*context {
+			j++
+		}
+		first, last := changes[i], changes[j-1]
+		aStart := max(first.A-context, 0)
+		bStart := max(first.B-context, 0)
+		aEnd := min(last.A+last.Del+context, len(a))
+		bEnd := min(last.B+last.Ins+context, len(b))
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aStart, aEnd-aStart), hunkRange(bStart, bEnd-bStart))
+		ai := aStart
+		for _, c := range changes[i:j] {
+			for ; ai < c.A; ai++ {
+				writeLine(&sb, ' ', a[ai])
+			}
+			for k := 0; k < c.Del; k++ {
+				writeLine(&sb, '-', a[c.A+k])
+			}
+			for k := 0; k < c.Ins; k++ {
+				writeLine(&sb, '+', b[c.B+k])
+			}
+			ai = c.A + c.Del
+		}
+		for ; ai < aEnd; ai++ {
+			writeLine(&sb, ' ', a[ai])
+		}
+		i = j
+	}
+	return sb.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeLine(sb *strings.Builder, prefix byte, line string) {
+	sb.WriteByte(prefix)
+	sb.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		sb.WriteString("\n\\ No newline at end of file\n")
+	}
+}
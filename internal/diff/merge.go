@@ -0,0 +1,100 @@
+package diff
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// Conflict markers written around lines both sides changed differently.
+const (
+	MarkerOurs   = "<<<<<<< "
+	MarkerSep    = "======="
+	MarkerTheirs = ">>>>>>> "
+)
+
+// sideChange is a change of one side of a three-way merge.
+type sideChange struct {
+	Change
+	ours bool
+}
+
+// Merge3 merges the line changes of ours and theirs against base. Changes of
+// one side are taken as they are, overlapping changes that differ are
+// written between conflict markers labeled with oursName and theirsName.
+// It reports whether there were conflicts.
+func Merge3(base, ours, theirs []string, oursName, theirsName string) ([]string, bool) {
+	var changes []sideChange
+	for _, c := range Lines(base, ours) {
+		changes = append(changes, sideChange{c, true})
+	}
+	for _, c := range Lines(base, theirs) {
+		changes = append(changes, sideChange{c, false})
+	}
+	slices.SortStableFunc(changes, func(a, b sideChange) int {
+		return cmp.Compare(a.A, b.A)
+	})
+
+	var merged []string
+	conflict := false
+	pos := 0
+	for i := 0; i < len(changes); {
+		// a region is a run of changes that overlap or touch in base
+		lo, hi := changes[i].A, changes[i].A+changes[i].Del
+		j := i + 1
+		for j < len(changes) && changes[j].A <= hi {
+			hi = max(hi, changes[j].A+changes[j].Del)
+			j++
+		}
+		region := changes[i:j]
+		i = j
+
+		merged = append(merged, base[pos:lo]...)
+		pos = hi
+
+		hasOurs := slices.ContainsFunc(region, func(c sideChange) bool { return c.ours })
+		hasTheirs := slices.ContainsFunc(region, func(c sideChange) bool { return !c.ours })
+		o := applyRegion(base, ours, region, true, lo, hi)
+		t := applyRegion(base, theirs, region, false, lo, hi)
+		switch {
+		case !hasTheirs:
+			merged = append(merged, o...)
+		case !hasOurs || slices.Equal(o, t):
+			merged = append(merged, t...)
+		default:
+			conflict = true
+			merged = append(merged, MarkerOurs+oursName+"\n")
+			merged = appendTerminated(merged, o)
+			merged = append(merged, MarkerSep+"\n")
+			merged = appendTerminated(merged, t)
+			merged = append(merged, MarkerTheirs+theirsName+"\n")
+		}
+	}
+	merged = append(merged, base[pos:]...)
+	return merged, conflict
+}
+
+// applyRegion returns the lines of one side that replace base[lo:hi].
+func applyRegion(base, side []string, region []sideChange, ours bool, lo, hi int) []string {
+	var out []string
+	pos := lo
+	for _, c := range region {
+		if c.ours != ours {
+			continue
+		}
+		out = append(out, base[pos:c.A]...)
+		out = append(out, side[c.B:c.B+c.Ins]...)
+		pos = c.A + c.Del
+	}
+	return append(out, base[pos:hi]...)
+}
+
+// appendTerminated appends lines and makes sure the last one ends with a
+// line break, so a following conflict marker starts on its own line.
+func appendTerminated(dst, lines []string) []string {
+	dst = append(dst, lines...)
+	if n := len(dst); n != 0 && !strings.HasSuffix(dst[n-1], "\n") {
+		dst[n-1] += "\n"
+	}
+	return dst
+}
@@ -0,0 +1,120 @@
+package diff
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+		conflict           bool
+	}{
+		{
+			name:   "unchanged",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nb\nc\n",
+		},
+		{
+			name:   "only ours",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "only theirs",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nc\nd\n",
+			want:   "a\nb\nc\nd\n",
+		},
+		{
+			name:   "identical changes",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nB\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "separate changes",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "A\nb\nc\nd\ne\n",
+			theirs: "a\nb\nc\nd\nE\n",
+			want:   "A\nb\nc\nd\nE\n",
+		},
+		{
+			name:     "overlapping changes",
+			base:     "a\nb\nc\n",
+			ours:     "a\nB\nc\n",
+			theirs:   "a\nX\nc\n",
+			want:     "a\n<<<<<<< ours\nB\n=======\nX\n>>>>>>> theirs\nc\n",
+			conflict: true,
+		},
+		{
+			name:     "insertions at the same line",
+			base:     "a\nb\n",
+			ours:     "a\nours\nb\n",
+			theirs:   "a\ntheirs\nb\n",
+			want:     "a\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\nb\n",
+			conflict: true,
+		},
+		{
+			name:     "deleted and changed",
+			base:     "a\nb\nc\n",
+			ours:     "a\nc\n",
+			theirs:   "a\nB\nc\n",
+			want:     "a\n<<<<<<< ours\n=======\nB\n>>>>>>> theirs\nc\n",
+			conflict: true,
+		},
+		{
+			name:   "missing trailing newline kept",
+			base:   "a\nb",
+			ours:   "A\nb",
+			theirs: "a\nb",
+			want:   "A\nb",
+		},
+		{
+			name:   "trailing newline added on one side",
+			base:   "a\nb",
+			ours:   "a\nb",
+			theirs: "a\nb\n",
+			want:   "a\nb\n",
+		},
+		{
+			name:     "missing trailing newline in conflict",
+			base:     "a\nb",
+			ours:     "a\nB",
+			theirs:   "a\nX",
+			want:     "a\n<<<<<<< ours\nB\n=======\nX\n>>>>>>> theirs\n",
+			conflict: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, conflict := Merge3(SplitLines(tt.base), SplitLines(tt.ours), SplitLines(tt.theirs), "ours", "theirs")
+			if got := strings.Join(merged, ""); got != tt.want {
+				t.Errorf("merged to %q, want %q", got, tt.want)
+			}
+			if conflict != tt.conflict {
+				t.Errorf("conflict is %v, want %v", conflict, tt.conflict)
+			}
+		})
+	}
+}
+
+func TestMerge3Symmetric(t *testing.T) {
+	base := SplitLines("a\nb\nc\nd\n")
+	ours := SplitLines("a\nB\nc\nd\n")
+	theirs := SplitLines("a\nb\nc\nD\n")
+	m1, c1 := Merge3(base, ours, theirs, "ours", "theirs")
+	m2, c2 := Merge3(base, theirs, ours, "theirs", "ours")
+	if !slices.Equal(m1, m2) || c1 || c2 {
+		t.Errorf("merges differ: %q and %q", m1, m2)
+	}
+}
@@ -0,0 +1,139 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConflictsDirName is the directory below the history directory that holds
+// the state of an interrupted checkout or merge and copies of both versions
+// of the conflicting files, named by hash.
+const ConflictsDirName = "conflicts"
+
+const conflictsFileName = "state.yaml"
+
+// Operations that can be interrupted by conflicts.
+const (
+	OperationCheckout = "checkout"
+	OperationMerge    = "merge"
+	OperationRebase   = "rebase"
+)
+
+// ConflictState records an operation that stopped on conflicting files.
+// For a checkout From is the commit that was checked out before and To the
+// new one, the local changes were moved into Stash. For a merge From is the
+// checked out commit and To the merged one. A rebase is a checkout of the
+// new head that publishes the local changes with Message and Paths once it
+// is continued; Local is the commit that was checked out before when it was
+// not on the branch.
+type ConflictState struct {
+	Operation string         `yaml:"operation"`
+	From      int64          `yaml:"from"`
+	To        int64          `yaml:"to"`
+	Stash     int64          `yaml:"stash,omitempty"`
+	Local     int64          `yaml:"local,omitempty"`
+	Message   string         `yaml:"message,omitempty"`
+	Paths     []string       `yaml:"paths,omitempty"`
+	Files     []ConflictFile `yaml:"files"`
+	dir       string
+}
+
+// ConflictFile is a path both sides changed. A missing side was deleted.
+// Markers is set when the file was written with conflict markers.
+type ConflictFile struct {
+	Path     string      `yaml:"path"`
+	Ours     *CommitFile `yaml:"ours,omitempty"`
+	Theirs   *CommitFile `yaml:"theirs,omitempty"`
+	Markers  bool        `yaml:"markers,omitempty"`
+	Resolved bool        `yaml:"resolved,omitempty"`
+}
+
+func conflictsDir() (string, error) {
+	hdp, err := findHistoryDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(hdp, ConflictsDirName), nil
+}
+
+// NewConflictState starts recording conflicts of an operation.
+func NewConflictState(operation string, from, to int64) (*ConflictState, error) {
+	dir, err := conflictsDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0764); err != nil {
+		return nil, errors.Join(errors.New("failed to create conflicts directory"), err)
+	}
+	return &ConflictState{Operation: operation, From: from, To: to, dir: dir}, nil
+}
+
+// LoadConflictState reads the recorded conflicts, it reports false if no
+// operation was interrupted.
+func LoadConflictState() (*ConflictState, bool, error) {
+	dir, err := conflictsDir()
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, conflictsFileName))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, errors.Join(errors.New("failed to read conflict state"), err)
+	}
+	s := &ConflictState{dir: dir}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, false, errors.Join(errors.New("failed to parse conflict state"), err)
+	}
+	return s, true, nil
+}
+
+func (s *ConflictState) Save() error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return errors.Join(errors.New("failed to encode conflict state"), err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, conflictsFileName), data, 0644); err != nil {
+		return errors.Join(errors.New("failed to write conflict state"), err)
+	}
+	return nil
+}
+
+// Remove deletes the state and the kept file versions.
+func (s *ConflictState) Remove() error {
+	if err := os.RemoveAll(s.dir); err != nil {
+		return errors.Join(errors.New("failed to remove conflict state"), err)
+	}
+	return nil
+}
+
+// ContentPath is where the kept version with hash h is stored.
+func (s *ConflictState) ContentPath(h string) string {
+	return filepath.Join(s.dir, h)
+}
+
+// File returns the conflict of a path.
+func (s *ConflictState) File(p string) (*ConflictFile, bool) {
+	i := slices.IndexFunc(s.Files, func(f ConflictFile) bool {
+		return f.Path == p
+	})
+	if i < 0 {
+		return nil, false
+	}
+	return &s.Files[i], true
+}
+
+// Unresolved returns the paths that still conflict.
+func (s *ConflictState) Unresolved() []string {
+	var paths []string
+	for _, f := range s.Files {
+		if !f.Resolved {
+			paths = append(paths, f.Path)
+		}
+	}
+	return paths
+}
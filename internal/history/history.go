@@ -1,30 +1,50 @@
 package history
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"scribe/internal/util"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type (
-	History []Commit
+	History []*Commit
 
 	Commit struct {
-		Created int64        `yaml:"created_at"`
-		Files   []CommitFile `yaml:"files"`
+		Created int64   `yaml:"created_at"`
+		Parents []int64 `yaml:"parents,omitempty"`
+		// Tree is the hash of the root tree object. Commits written before
+		// trees were introduced list their files inline instead.
+		Tree    string       `yaml:"tree,omitempty"`
+		Files   []CommitFile `yaml:"files,omitempty"`
 		Message string       `yaml:"message"`
 		Ignore  string       `yaml:"ignore"`
-		fp      string       `yaml:"-"`
+		// Signature is an armored SSH signature of SignedData.
+		Signature string `yaml:"signature,omitempty"`
+		fp        string `yaml:"-"`
+		// index maps paths to positions in indexed, the Files it was
+		// built for, see File.
+		index   map[string]int `yaml:"-"`
+		indexed []CommitFile   `yaml:"-"`
 	}
 
 	CommitFile struct {
-		Path string
-		Hash string
+		Path string      `yaml:"path"`
+		Hash string      `yaml:"hash,omitempty"`
+		Type string      `yaml:"type,omitempty"`
+		Mode fs.FileMode `yaml:"mode,omitempty"`
+		// Size is the uncompressed content size. It is unknown for files
+		// committed before sizes were recorded.
+		Size int64 `yaml:"size,omitempty"`
 	}
 )
 
@@ -32,6 +52,14 @@ const (
 	HistoryDirName = ".scribe"
 )
 
+const (
+	FileTypeRegular = ""
+	// FileTypeSymlink entries store the link target as their object.
+	FileTypeSymlink = "symlink"
+	// FileTypeDir entries are empty directories, they have no object.
+	FileTypeDir = "dir"
+)
+
 func findHistoryDir() (string, error) {
 	wd, err := os.Getwd()
 	if err != nil {
@@ -46,6 +74,7 @@ func findHistoryDir() (string, error) {
 		if parent == wd || parent == "/" || parent == "." {
 			break
 		}
+		wd = parent
 	}
 	return "", errors.New("no " + HistoryDirName + "/ found")
 }
@@ -57,27 +86,341 @@ func Init() error {
 	return nil
 }
 
-func (c *Commit) Save() error {
+// ParseID parses a commit id as printed by scribe (hexadecimal).
+func ParseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimSpace(s), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid commit id %q", s)
+	}
+	return id, nil
+}
+
+// FindID returns the id of the single local commit whose id starts with prefix.
+func FindID(prefix string) (int64, error) {
+	hdp, err := findHistoryDir()
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(hdp)
+	if err != nil {
+		return 0, errors.Join(errors.New("failed to read history directory"), err)
+	}
+
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	var found []int64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".yaml") || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if id, err := strconv.ParseInt(strings.TrimSuffix(name, ".yaml"), 16, 64); err == nil {
+			found = append(found, id)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return 0, fmt.Errorf("unknown commit %s", prefix)
+	case 1:
+		return found[0], nil
+	default:
+		return 0, fmt.Errorf("commit id %s is ambiguous", prefix)
+	}
+}
+
+func (c *Commit) ID() string {
+	return fmt.Sprintf("%x", c.Created)
+}
+
+// Load reads a single commit from the local history directory
+// and expands its tree into the list of files.
+func Load(id int64) (*Commit, error) {
+	c, err := load(id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Parents == nil {
+		ids, err := commitIDs()
+		if err != nil {
+			return nil, err
+		}
+		inferParents(c, ids)
+	}
+	if err := c.LoadFiles(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func load(id int64) (*Commit, error) {
+	hdp, err := findHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Commit{Created: id, fp: filepath.Join(hdp, fmt.Sprintf("%x.yaml", id))}
+	f, err := os.Open(c.fp)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to open commit file for commit %x", id), err)
+	}
+	defer f.Close()
+
+	yd := yaml.NewDecoder(f)
+	if err := yd.Decode(c); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to decode commit file for commit %x", id), err)
+	}
+	if err := c.CheckHashes(); err != nil {
+		return nil, errors.Join(fmt.Errorf("invalid commit file for commit %x", id), err)
+	}
+
+	return c, nil
+}
+
+// CheckHashes refuses commits whose tree or inline files don't reference
+// valid object hashes. Directories have no object.
+func (c *Commit) CheckHashes() error {
+	if len(c.Tree) != 0 {
+		if err := util.CheckHash(c.Tree); err != nil {
+			return err
+		}
+	}
+	for _, f := range c.Files {
+		if f.Type == FileTypeDir {
+			continue
+		}
+		if err := util.CheckHash(f.Hash); err != nil {
+			return errors.Join(fmt.Errorf("invalid file %s", f.Path), err)
+		}
+	}
+	return nil
+}
+
+// LoadAll reads every commit from the local history directory, oldest first.
+// Trees are not expanded, use LoadFiles where the files are needed.
+func LoadAll() (History, error) {
+	ids, err := commitIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	h := make(History, 0, len(ids))
+	for _, id := range ids {
+		c, err := load(id)
+		if err != nil {
+			return nil, err
+		}
+		inferParents(c, ids)
+		h = append(h, c)
+	}
+	return h, nil
+}
+
+// commitIDs lists the ids of the commits in the local history directory,
+// oldest first.
+func commitIDs() ([]int64, error) {
+	hdp, err := findHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(hdp)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to read history directory"), err)
+	}
+
+	ids := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".yaml") {
+			continue
+		}
+		if id, err := strconv.ParseInt(strings.TrimSuffix(name, ".yaml"), 16, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// inferParents gives commits written before parents were recorded the
+// chronologically previous commit of ids as their parent, which is how
+// history was interpreted back then. Only the oldest commit is left without
+// a parent.
+func inferParents(c *Commit, ids []int64) {
+	if c.Parents != nil {
+		return
+	}
+	if i, _ := slices.BinarySearch(ids, c.Created); i > 0 {
+		c.Parents = []int64{ids[i-1]}
+	}
+}
+
+func (h History) Get(id int64) (*Commit, bool) {
+	i, found := slices.BinarySearchFunc(h, id, func(c *Commit, id int64) int {
+		return cmp.Compare(c.Created, id)
+	})
+	if !found {
+		return nil, false
+	}
+	return h[i], true
+}
+
+// Ancestors returns the ids of all commits reachable from id, including id itself.
+func (h History) Ancestors(id int64) map[int64]struct{} {
+	seen := make(map[int64]struct{})
+	queue := []int64{id}
+	for len(queue) != 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[cur]; ok {
+			continue
+		}
+		seen[cur] = struct{}{}
+		if c, ok := h.Get(cur); ok {
+			queue = append(queue, c.Parents...)
+		}
+	}
+	return seen
+}
+
+// IsAncestor reports whether ancestor is reachable from descendant.
+// A commit is considered an ancestor of itself.
+func (h History) IsAncestor(ancestor, descendant int64) bool {
+	_, ok := h.Ancestors(descendant)[ancestor]
+	return ok
+}
+
+// MergeBase returns the newest common ancestor of a and b.
+func (h History) MergeBase(a, b int64) (*Commit, bool) {
+	aa := h.Ancestors(a)
+	ba := h.Ancestors(b)
+	var base *Commit
+	for id := range aa {
+		if _, ok := ba[id]; !ok {
+			continue
+		}
+		if c, ok := h.Get(id); ok && (base == nil || c.Created > base.Created) {
+			base = c
+		}
+	}
+	return base, base != nil
+}
+
+// Log returns the commits reachable from any of ids, newest first.
+func (h History) Log(ids ...int64) History {
+	ancestors := make(map[int64]struct{})
+	for _, id := range ids {
+		for a := range h.Ancestors(id) {
+			ancestors[a] = struct{}{}
+		}
+	}
+	log := make(History, 0, len(ancestors))
+	for i := len(h) - 1; i >= 0; i-- {
+		if _, ok := ancestors[h[i].Created]; ok {
+			log = append(log, h[i])
+		}
+	}
+	return log
+}
+
+// At returns the newest commit on the first-parent line of head that was
+// created at or before t.
+func (h History) At(head int64, t int64) (*Commit, bool) {
+	c, ok := h.Get(head)
+	for ok && c.Created > t {
+		if len(c.Parents) == 0 {
+			return nil, false
+		}
+		c, ok = h.Get(c.Parents[0])
+	}
+	return c, ok
+}
+
+// AssignID gives a new commit its id and file path, Save does so as well.
+func (c *Commit) AssignID() error {
+	if len(c.fp) != 0 {
+		return nil
+	}
+	hdp, err := findHistoryDir()
+	if err != nil {
+		return err
+	}
 	if c.Created == 0 {
+		// commit ids are timestamps, make sure two commits created
+		// within the same second don't share one
 		c.Created = time.Now().Unix()
+		for util.Exists(filepath.Join(hdp, c.FileName())) {
+			c.Created++
+		}
 	}
+	c.fp = filepath.Join(hdp, c.FileName())
+	return nil
+}
 
-	if len(c.fp) == 0 {
-		hdp, err := findHistoryDir()
-		if err != nil {
+// Renumber moves a saved commit to the next free id, for when its id turned
+// out to be taken on the remote. The signature is dropped as it covers the
+// id, sign and save the commit again afterwards.
+func (c *Commit) Renumber() error {
+	if len(c.fp) != 0 {
+		if err := os.Remove(c.fp); err != nil && !os.IsNotExist(err) {
 			return err
 		}
-		c.fp = filepath.Join(hdp, fmt.Sprintf("%x.yaml", c.Created))
+	}
+	hdp, err := findHistoryDir()
+	if err != nil {
+		return err
+	}
+	c.Created++
+	for util.Exists(filepath.Join(hdp, c.FileName())) {
+		c.Created++
+	}
+	c.fp = filepath.Join(hdp, c.FileName())
+	c.Signature = ""
+	return nil
+}
+
+func (c *Commit) Save() error {
+	if err := c.AssignID(); err != nil {
+		return err
 	}
 
 	f, err := os.Create(c.fp)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
+
+	out := *c
+	if len(out.Tree) != 0 {
+		// the files are stored in the tree objects
+		out.Files = nil
+	}
 
 	ye := yaml.NewEncoder(f)
 	defer ye.Close()
-	return ye.Encode(c)
+	return ye.Encode(&out)
+}
+
+// SignedData is the content covered by the commit signature: the commit as
+// it is saved, without the signature itself.
+func (c *Commit) SignedData() ([]byte, error) {
+	out := *c
+	out.Signature = ""
+	if len(out.Tree) != 0 {
+		out.Files = nil
+	}
+	return yaml.Marshal(&out)
+}
+
+// LoadFiles expands the commit tree from the local tree cache.
+func (c *Commit) LoadFiles() error {
+	if len(c.Tree) == 0 || c.Files != nil {
+		return nil
+	}
+	files, err := FlattenTree(c.Tree)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to expand tree of commit %x", c.Created), err)
+	}
+	c.Files = files
+	return nil
 }
 
 func (c *Commit) Open() (*os.File, error) {
@@ -103,11 +446,68 @@ func (c *Commit) FileName() string {
 	return fmt.Sprintf("%x.yaml", c.Created)
 }
 
+// UnloadFiles frees the expanded file list of commits that store it in trees.
+func (c *Commit) UnloadFiles() {
+	if len(c.Tree) == 0 {
+		return
+	}
+	c.Files = nil
+	c.index = nil
+	c.indexed = nil
+}
+
+// File looks up a file by its path. The index is rebuilt when Files was
+// replaced since it was built, or when a hit shows it was changed in place.
 func (c *Commit) File(name string) (CommitFile, bool) {
+	if !c.indexFresh() {
+		c.buildIndex()
+	}
+	i, ok := c.index[name]
+	if ok && c.Files[i].Path != name {
+		c.buildIndex()
+		i, ok = c.index[name]
+	}
+	if ok {
+		return c.Files[i], true
+	}
+	return CommitFile{}, false
+}
+
+func (c *Commit) indexFresh() bool {
+	if c.index == nil || len(c.indexed) != len(c.Files) {
+		return false
+	}
+	return len(c.Files) == 0 || &c.indexed[0] == &c.Files[0]
+}
+
+func (c *Commit) buildIndex() {
+	c.index = make(map[string]int, len(c.Files))
+	for i, f := range c.Files {
+		c.index[f.Path] = i
+	}
+	c.indexed = c.Files
+}
+
+// Size is the total size of all files. It reports false if the size of any
+// file is unknown. Sizes of zero are omitted, so emptyHash, the hash of empty
+// content, tells empty files apart from files without a recorded size.
+func (c *Commit) Size(emptyHash string) (int64, bool) {
+	var size int64
 	for _, f := range c.Files {
-		if f.Path == name {
-			return f, true
+		if f.Size == 0 && f.Type != FileTypeDir && f.Hash != emptyHash {
+			return 0, false
 		}
+		size += f.Size
 	}
-	return CommitFile{}, false
+	return size, true
+}
+
+func (cf CommitFile) Executable() bool {
+	return cf.Mode&0111 != 0
+}
+
+// Same reports whether both entries have the same type, content and
+// executable bit. Other permission bits depend on the umask and are ignored.
+func (cf CommitFile) Same(other CommitFile) bool {
+	return cf.Type == other.Type && cf.Hash == other.Hash && cf.Executable() == other.Executable()
 }
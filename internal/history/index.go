@@ -0,0 +1,144 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IndexFileName is the file below the history directory that holds the
+// staged changes.
+const IndexFileName = "index.yaml"
+
+// Index is the staging area. It records the files as they were when they
+// were staged, so later edits don't end up in the next commit.
+type Index struct {
+	Entries []IndexEntry `yaml:"entries"`
+}
+
+// IndexEntry is a staged file, or a staged deletion.
+type IndexEntry struct {
+	CommitFile `yaml:",inline"`
+	Deleted    bool `yaml:"deleted,omitempty"`
+}
+
+func indexPath() (string, error) {
+	hdp, err := findHistoryDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(hdp, IndexFileName), nil
+}
+
+// LoadIndex reads the index, a missing index is empty.
+func LoadIndex() (*Index, error) {
+	ip, err := indexPath()
+	if err != nil {
+		return nil, err
+	}
+	idx := &Index{}
+	data, err := os.ReadFile(ip)
+	if os.IsNotExist(err) {
+		return idx, nil
+	} else if err != nil {
+		return nil, errors.Join(errors.New("failed to read index"), err)
+	}
+	if err := yaml.Unmarshal(data, idx); err != nil {
+		return nil, errors.Join(errors.New("failed to parse index"), err)
+	}
+	return idx, nil
+}
+
+// Save writes the index, an empty index is removed.
+func (idx *Index) Save() error {
+	ip, err := indexPath()
+	if err != nil {
+		return err
+	}
+	if idx.Empty() {
+		if err := os.Remove(ip); err != nil && !os.IsNotExist(err) {
+			return errors.Join(errors.New("failed to remove index"), err)
+		}
+		return nil
+	}
+	data, err := yaml.Marshal(idx)
+	if err != nil {
+		return errors.Join(errors.New("failed to encode index"), err)
+	}
+	if err := os.WriteFile(ip, data, 0644); err != nil {
+		return errors.Join(errors.New("failed to write index"), err)
+	}
+	return nil
+}
+
+func (idx *Index) Empty() bool {
+	return len(idx.Entries) == 0
+}
+
+// Get returns the staged entry of a path.
+func (idx *Index) Get(p string) (IndexEntry, bool) {
+	i, found := slices.BinarySearchFunc(idx.Entries, p, comparePath)
+	if !found {
+		return IndexEntry{}, false
+	}
+	return idx.Entries[i], true
+}
+
+// Stage records a file or replaces its staged version.
+func (idx *Index) Stage(cf CommitFile) {
+	idx.set(IndexEntry{CommitFile: cf})
+}
+
+// StageDelete records the deletion of a path.
+func (idx *Index) StageDelete(p string) {
+	idx.set(IndexEntry{CommitFile: CommitFile{Path: p}, Deleted: true})
+}
+
+func (idx *Index) set(e IndexEntry) {
+	i, found := slices.BinarySearchFunc(idx.Entries, e.Path, comparePath)
+	if found {
+		idx.Entries[i] = e
+	} else {
+		idx.Entries = slices.Insert(idx.Entries, i, e)
+	}
+}
+
+func comparePath(e IndexEntry, p string) int {
+	return strings.Compare(e.Path, p)
+}
+
+// Unstage drops the entries selected by match and returns their paths.
+func (idx *Index) Unstage(match func(p string) bool) []string {
+	var dropped []string
+	idx.Entries = slices.DeleteFunc(idx.Entries, func(e IndexEntry) bool {
+		if match(e.Path) {
+			dropped = append(dropped, e.Path)
+			return true
+		}
+		return false
+	})
+	return dropped
+}
+
+// Apply returns the files of c with the staged changes applied.
+func (idx *Index) Apply(c *Commit) []CommitFile {
+	files := make([]CommitFile, 0, len(c.Files)+len(idx.Entries))
+	for _, f := range c.Files {
+		if _, ok := idx.Get(f.Path); !ok {
+			files = append(files, f)
+		}
+	}
+	for _, e := range idx.Entries {
+		if !e.Deleted {
+			files = append(files, e.CommitFile)
+		}
+	}
+	slices.SortFunc(files, func(a, b CommitFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return files
+}
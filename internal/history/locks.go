@@ -0,0 +1,90 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LocksFileName is the file below the history directory that holds the file
+// locks as they were last read from the remote.
+const LocksFileName = "locks.yaml"
+
+// PathLock is an exclusive lock of a file. Only its owner may commit changes
+// to the file until it is unlocked. The owner is the SSH login user, so
+// everyone sharing one account on the remote counts as the same owner.
+type PathLock struct {
+	Path    string `yaml:"path"`
+	Owner   string `yaml:"owner"`
+	Host    string `yaml:"host"`
+	Created int64  `yaml:"created"`
+}
+
+// Locks is the local copy of the file locks on the remote, sorted by path.
+// Updated is when they were read.
+type Locks struct {
+	Updated int64      `yaml:"updated"`
+	Locks   []PathLock `yaml:"locks"`
+}
+
+func locksPath() (string, error) {
+	hdp, err := findHistoryDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(hdp, LocksFileName), nil
+}
+
+// LoadLocks reads the local copy of the file locks, it is empty if they
+// were never read.
+func LoadLocks() (*Locks, error) {
+	lp, err := locksPath()
+	if err != nil {
+		return nil, err
+	}
+	l := &Locks{}
+	data, err := os.ReadFile(lp)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return nil, errors.Join(errors.New("failed to read locks"), err)
+	}
+	if err := yaml.Unmarshal(data, l); err != nil {
+		return nil, errors.Join(errors.New("failed to parse locks"), err)
+	}
+	return l, nil
+}
+
+// Save writes the local copy of the file locks.
+func (l *Locks) Save() error {
+	lp, err := locksPath()
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(l.Locks, func(a, b PathLock) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return errors.Join(errors.New("failed to encode locks"), err)
+	}
+	if err := os.WriteFile(lp, data, 0644); err != nil {
+		return errors.Join(errors.New("failed to write locks"), err)
+	}
+	return nil
+}
+
+// Get returns the lock of path p.
+func (l *Locks) Get(p string) (*PathLock, bool) {
+	i := slices.IndexFunc(l.Locks, func(pl PathLock) bool {
+		return pl.Path == p
+	})
+	if i < 0 {
+		return nil, false
+	}
+	return &l.Locks[i], true
+}
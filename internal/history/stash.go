@@ -0,0 +1,125 @@
+package history
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"scribe/internal/util"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StashDirName is the directory below the history directory that holds the
+// stashed changes, one directory per stash.
+const StashDirName = "stash"
+
+const stashFileName = "stash.yaml"
+
+// Stash is a set of local changes that was moved out of the working tree.
+// The content of stashed files is kept next to it, named by hash.
+type Stash struct {
+	Created int64       `yaml:"created_at"`
+	Base    int64       `yaml:"base"`
+	Message string      `yaml:"message,omitempty"`
+	Files   []StashFile `yaml:"files"`
+	dir     string
+}
+
+// StashFile is a stashed change. BaseHash is the hash of the file in the
+// base commit, empty if the file was created.
+type StashFile struct {
+	CommitFile `yaml:",inline"`
+	BaseHash   string `yaml:"base_hash,omitempty"`
+	Deleted    bool   `yaml:"deleted,omitempty"`
+}
+
+func stashDir() (string, error) {
+	hdp, err := findHistoryDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(hdp, StashDirName), nil
+}
+
+// NewStash creates an empty stash for changes on top of commit base.
+func NewStash(base int64, msg string) (*Stash, error) {
+	sd, err := stashDir()
+	if err != nil {
+		return nil, err
+	}
+	s := &Stash{Created: time.Now().Unix(), Base: base, Message: msg}
+	for util.Exists(filepath.Join(sd, fmt.Sprintf("%x", s.Created))) {
+		s.Created++
+	}
+	s.dir = filepath.Join(sd, fmt.Sprintf("%x", s.Created))
+	if err := os.MkdirAll(s.dir, 0764); err != nil {
+		return nil, errors.Join(errors.New("failed to create stash directory"), err)
+	}
+	return s, nil
+}
+
+// Stashes returns all stashes, newest first.
+func Stashes() ([]*Stash, error) {
+	sd, err := stashDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(sd)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Join(errors.New("failed to read stash directory"), err)
+	}
+
+	stashes := make([]*Stash, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(sd, e.Name())
+		data, err := os.ReadFile(filepath.Join(dir, stashFileName))
+		if os.IsNotExist(err) {
+			// an interrupted stash push
+			continue
+		} else if err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to read stash %s", e.Name()), err)
+		}
+		s := &Stash{dir: dir}
+		if err := yaml.Unmarshal(data, s); err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to parse stash %s", e.Name()), err)
+		}
+		stashes = append(stashes, s)
+	}
+	slices.SortFunc(stashes, func(a, b *Stash) int {
+		return cmp.Compare(b.Created, a.Created)
+	})
+	return stashes, nil
+}
+
+// ContentPath is where the stashed content with hash h is kept.
+func (s *Stash) ContentPath(h string) string {
+	return filepath.Join(s.dir, h)
+}
+
+func (s *Stash) Save() error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return errors.Join(errors.New("failed to encode stash"), err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, stashFileName), data, 0644); err != nil {
+		return errors.Join(errors.New("failed to write stash"), err)
+	}
+	return nil
+}
+
+// Drop deletes the stash and its content.
+func (s *Stash) Drop() error {
+	if err := os.RemoveAll(s.dir); err != nil {
+		return errors.Join(fmt.Errorf("failed to drop stash %x", s.Created), err)
+	}
+	return nil
+}
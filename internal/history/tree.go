@@ -0,0 +1,232 @@
+package history
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"scribe/internal/util"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Tree is the content of a single directory. Trees are content-addressed
+	// by the hash of their encoding, so unchanged directories share the same
+	// tree object between commits.
+	Tree struct {
+		Entries []TreeEntry `yaml:"entries"`
+	}
+
+	TreeEntry struct {
+		Name string      `yaml:"name"`
+		Hash string      `yaml:"hash"`
+		Type string      `yaml:"type,omitempty"`
+		Mode fs.FileMode `yaml:"mode,omitempty"`
+		Size int64       `yaml:"size,omitempty"`
+	}
+)
+
+const (
+	TreesDirName = "trees"
+
+	EntryTypeFile    = FileTypeRegular
+	EntryTypeSymlink = FileTypeSymlink
+	// EntryTypeTree entries are subdirectories, an empty directory is a
+	// tree without entries.
+	EntryTypeTree = "tree"
+)
+
+func TreePath(hash string) (string, error) {
+	if err := util.CheckHash(hash); err != nil {
+		return "", err
+	}
+	hdp, err := findHistoryDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(hdp, TreesDirName, hash+".yaml"), nil
+}
+
+// HasTree reports whether the tree is in the local tree cache.
+// A cached tree implies that all of its subtrees are cached as well.
+func HasTree(hash string) bool {
+	tp, err := TreePath(hash)
+	if err != nil {
+		return false
+	}
+	return util.Exists(tp)
+}
+
+func LoadTree(hash string) (*Tree, error) {
+	tp, err := TreePath(hash)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeTree(tp)
+}
+
+func DecodeTree(name string) (*Tree, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to open tree file"), err)
+	}
+	defer f.Close()
+
+	t := &Tree{}
+	if err := yaml.NewDecoder(f).Decode(t); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to decode tree file %s", name), err)
+	}
+	for _, e := range t.Entries {
+		if err := util.CheckHash(e.Hash); err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid entry %s in tree file %s", e.Name, name), err)
+		}
+	}
+	return t, nil
+}
+
+// HashFunc hashes object content, see config.Config.HashReader.
+type HashFunc func(r io.Reader) (string, error)
+
+func (t *Tree) encode(hash HashFunc) ([]byte, string, error) {
+	var buf bytes.Buffer
+	ye := yaml.NewEncoder(&buf)
+	if err := ye.Encode(t); err != nil {
+		return nil, "", err
+	}
+	if err := ye.Close(); err != nil {
+		return nil, "", err
+	}
+	h, err := hash(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), h, nil
+}
+
+// SaveTree writes an encoded tree into the local tree cache.
+func SaveTree(hash string, data []byte) error {
+	tp, err := TreePath(hash)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(tp), 0764); err != nil {
+		return errors.Join(errors.New("failed to create tree cache directory"), err)
+	}
+	return os.WriteFile(tp, data, 0664)
+}
+
+// BuildTrees groups the given files by directory and encodes one tree per
+// directory. It returns the hash of the root tree and every encoded tree by
+// its hash.
+func BuildTrees(files []CommitFile, hash HashFunc) (string, map[string][]byte, error) {
+	dirs := map[string]*Tree{"": {}}
+	var ensureDir func(dir string) *Tree
+	ensureDir = func(dir string) *Tree {
+		if t, ok := dirs[dir]; ok {
+			return t
+		}
+		t := &Tree{}
+		dirs[dir] = t
+		parent := ensureDir(parentDir(dir))
+		parent.Entries = append(parent.Entries, TreeEntry{Name: path.Base(dir), Type: EntryTypeTree})
+		return t
+	}
+
+	for _, f := range files {
+		if f.Type == FileTypeDir {
+			ensureDir(f.Path)
+			continue
+		}
+		t := ensureDir(parentDir(f.Path))
+		t.Entries = append(t.Entries, TreeEntry{Name: path.Base(f.Path), Hash: f.Hash, Type: f.Type, Mode: f.Mode, Size: f.Size})
+	}
+
+	// encode deepest directories first, so subtree hashes are known
+	names := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Compare(strings.Count(b, "/"), strings.Count(a, "/"))
+	})
+
+	hashes := make(map[string]string, len(dirs))
+	encoded := make(map[string][]byte, len(dirs))
+	for _, dir := range names {
+		if dir == "" {
+			continue
+		}
+		if err := encodeDir(dir, dirs[dir], hash, hashes, encoded); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := encodeDir("", dirs[""], hash, hashes, encoded); err != nil {
+		return "", nil, err
+	}
+
+	return hashes[""], encoded, nil
+}
+
+func encodeDir(dir string, t *Tree, hash HashFunc, hashes map[string]string, encoded map[string][]byte) error {
+	for i, e := range t.Entries {
+		if e.Type == EntryTypeTree {
+			t.Entries[i].Hash = hashes[path.Join(dir, e.Name)]
+		}
+	}
+	slices.SortFunc(t.Entries, func(a, b TreeEntry) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	data, h, err := t.encode(hash)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to encode tree %s", dir), err)
+	}
+	hashes[dir] = h
+	encoded[h] = data
+	return nil
+}
+
+func parentDir(p string) string {
+	dir := path.Dir(p)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
+
+// FlattenTree expands a cached tree into the list of files it contains.
+func FlattenTree(hash string) ([]CommitFile, error) {
+	var files []CommitFile
+	var walk func(hash string, prefix string) error
+	walk = func(hash string, prefix string) error {
+		t, err := LoadTree(hash)
+		if err != nil {
+			return errors.Join(fmt.Errorf("failed to load tree %s", hash), err)
+		}
+		for _, e := range t.Entries {
+			p := path.Join(prefix, e.Name)
+			if e.Type == EntryTypeTree {
+				if err := walk(e.Hash, p); err != nil {
+					return err
+				}
+				continue
+			}
+			files = append(files, CommitFile{Path: p, Hash: e.Hash, Type: e.Type, Mode: e.Mode, Size: e.Size})
+		}
+		if len(t.Entries) == 0 && len(prefix) != 0 {
+			files = append(files, CommitFile{Path: prefix, Type: FileTypeDir})
+		}
+		return nil
+	}
+	if err := walk(hash, ""); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
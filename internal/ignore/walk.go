@@ -0,0 +1,53 @@
+package ignore
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"scribe/internal/config"
+	"scribe/internal/util"
+	"strings"
+)
+
+// Walk walks the working tree below root and calls fn for every entry that
+// can be tracked: regular files, symlinks and empty directories. Ignored
+// paths are skipped and symlinks are not followed. repoPath is slash separated
+// and relative to root.
+func Walk(c *config.Config, root string, fn func(repoPath string, absPath string, d fs.DirEntry) error) error {
+	m := GetMatcher(c)
+	return filepath.WalkDir(root, func(absPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, absPath)
+		if err != nil {
+			return errors.Join(errors.New("failed to get relative path"), err)
+		}
+		gitPath := util.TrimSliceEmptyString(strings.Split(rel, string(filepath.Separator)))
+		if len(gitPath) == 1 && gitPath[0] == "." {
+			// the root itself is never tracked
+			return nil
+		}
+		isDir := d.IsDir()
+		if m.Match(gitPath, isDir) {
+			// excluded from ignore
+			if isDir {
+				return filepath.SkipDir
+			} else {
+				return nil
+			}
+		}
+		if isDir {
+			entries, err := os.ReadDir(absPath)
+			if err != nil {
+				return errors.Join(errors.New("failed to read directory"), err)
+			}
+			if len(entries) != 0 {
+				return nil
+			}
+		}
+		return fn(strings.Join(gitPath, "/"), absPath, d)
+	})
+}
@@ -1,6 +1,26 @@
 package options
 
 var (
-	FlagForce   bool
-	FlagMessage []string = []string{}
+	FlagForce           bool
+	FlagMessage         []string = []string{}
+	FlagDryRun          bool
+	FlagRemote          bool
+	FlagSince           string
+	FlagUntil           string
+	FlagGrep            string
+	FlagLimit           int
+	FlagAt              string
+	FlagSource          string
+	FlagHard            bool
+	FlagCached          bool
+	FlagDelete          bool
+	FlagCreate          bool
+	FlagList            bool
+	FlagContinue        bool
+	FlagAbort           bool
+	FlagOurs            bool
+	FlagTheirs          bool
+	FlagMerged          bool
+	FlagRebase          bool
+	FlagAllowUnverified bool
 )
@@ -0,0 +1,39 @@
+package remote
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"scribe/internal/history"
+	"scribe/internal/util"
+)
+
+// ObjectCacheDirName is the directory below the history directory where
+// object contents fetched for inspection are kept.
+const ObjectCacheDirName = "cache"
+
+// ObjectContent returns the verified content of a commit file. Objects are
+// taken from the local object cache, or fetched from the remote and cached.
+func (r *Remote) ObjectContent(cf history.CommitFile) ([]byte, error) {
+	cp, err := r.ObjectContentPath(cf)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(cp)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to read cached object"), err)
+	}
+	return b, nil
+}
+
+// ObjectContentPath returns the path of the verified content of a commit
+// file in the local object cache, fetching it if necessary.
+func (r *Remote) ObjectContentPath(cf history.CommitFile) (string, error) {
+	cp := filepath.Join(r.LocalWD(), history.HistoryDirName, ObjectCacheDirName, cf.Hash)
+	if !util.Exists(cp) {
+		if err := r.readVerifiedFile(cf.Hash, cf.Path, cp); err != nil {
+			return "", err
+		}
+	}
+	return cp, nil
+}
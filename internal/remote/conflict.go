@@ -0,0 +1,438 @@
+package remote
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+	"slices"
+	"strings"
+)
+
+// Suffixes of the files both versions of a conflicting file are written to.
+const (
+	SuffixMine   = ".mine"
+	SuffixTheirs = ".theirs"
+)
+
+// Ways to resolve a conflict.
+const (
+	ResolveOurs   = "ours"
+	ResolveTheirs = "theirs"
+	ResolveMerged = "merged"
+)
+
+// ConflictError is returned when an operation stopped on conflicts. The
+// conflicts are recorded and have to be resolved before it can continue.
+type ConflictError struct {
+	Operation string
+	Paths     []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s stopped on %d conflicting files: %s", e.Operation, len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+// conflictSide is one version of a conflicting file and where its content
+// can be read locally. A nil file was deleted on that side.
+type conflictSide struct {
+	file    *history.CommitFile
+	content string
+}
+
+// side returns the conflict side of a commit file with its content from the
+// local object cache.
+func (r *Remote) side(cf *history.CommitFile) (conflictSide, error) {
+	s := conflictSide{file: cf}
+	if cf == nil || cf.Type == history.FileTypeDir {
+		return s, nil
+	}
+	var err error
+	s.content, err = r.ObjectContentPath(*cf)
+	return s, err
+}
+
+// checkoutWithConflicts checks out commit c although local changes conflict
+// with it. The local changes are stashed, the commit is checked out and the
+// changes are reapplied. Text files changed on both sides are merged line by
+// line, the remaining conflicts are written to the working tree and recorded.
+func (r *Remote) checkoutWithConflicts(cur *history.Commit, c *history.Commit) error {
+	s, err := r.StashPush(fmt.Sprintf("local changes before checking out %x", c.Created))
+	if err != nil {
+		return errors.Join(errors.New("failed to stash local changes"), err)
+	}
+	if err := r.CheckoutCommit(c); err != nil {
+		return err
+	}
+
+	state, err := history.NewConflictState(history.OperationCheckout, cur.Created, c.Created)
+	if err != nil {
+		return err
+	}
+	state.Stash = s.Created
+
+	for _, sf := range s.Files {
+		absPath, err := r.workingPath(sf.Path)
+		if err != nil {
+			return err
+		}
+		local, exists, err := r.localFile(absPath, sf.Path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case sf.Deleted && !exists, !sf.Deleted && exists && local.Type == sf.Type && local.Hash == sf.Hash:
+			continue
+		case sf.BaseHash == "" && !exists, sf.BaseHash != "" && exists && local.Hash == sf.BaseHash:
+			if err := r.applyStashFile(s, sf, absPath, c); err != nil {
+				return errors.Join(fmt.Errorf("failed to apply %s", sf.Path), err)
+			}
+			continue
+		}
+
+		base, err := r.side(fileOf(cur, sf.Path))
+		if err != nil {
+			return err
+		}
+		theirs, err := r.side(fileOf(c, sf.Path))
+		if err != nil {
+			return err
+		}
+		var ours conflictSide
+		if !sf.Deleted {
+			ours.file = &sf.CommitFile
+			if sf.Type != history.FileTypeDir {
+				ours.content = s.ContentPath(sf.Hash)
+			}
+		}
+		if err := r.writeConflict(state, sf.Path, base, ours, theirs, fmt.Sprintf("%x", c.Created)); err != nil {
+			return errors.Join(fmt.Errorf("failed to write conflict of %s", sf.Path), err)
+		}
+	}
+
+	if len(state.Files) == 0 {
+		if err := state.Remove(); err != nil {
+			return err
+		}
+		return s.Drop()
+	}
+	if err := state.Save(); err != nil {
+		return err
+	}
+	return &ConflictError{Operation: state.Operation, Paths: state.Unresolved()}
+}
+
+// mergeWithConflicts writes the result of a merge with conflicts to the
+// clean working tree of ours and records the conflicts.
+func (r *Remote) mergeWithConflicts(ours, theirs *history.Commit, result *MergeResult) error {
+	if changes, err := diff.LocalFromCommit(r.Config, ours); err != nil {
+		return errors.Join(errors.New("failed to diff local changes"), err)
+	} else if len(changes) != 0 {
+		return errors.Join(errors.New("commit or stash the local changes before merging"), &MergeConflictError{Conflicts: result.Conflicts})
+	}
+
+	// conflicting paths keep our version until the conflict is written
+	merged := &history.Commit{Created: ours.Created, Files: slices.Clone(result.Files)}
+	for _, mc := range result.Conflicts {
+		if mc.Ours != nil {
+			merged.Files = append(merged.Files, *mc.Ours)
+		}
+	}
+	if err := r.updateWorkingTree(ours, merged, nil); err != nil {
+		return err
+	}
+
+	state, err := history.NewConflictState(history.OperationMerge, ours.Created, theirs.Created)
+	if err != nil {
+		return err
+	}
+	for _, mc := range result.Conflicts {
+		base, err := r.side(mc.Base)
+		if err != nil {
+			return err
+		}
+		o, err := r.side(mc.Ours)
+		if err != nil {
+			return err
+		}
+		t, err := r.side(mc.Theirs)
+		if err != nil {
+			return err
+		}
+		if err := r.writeConflict(state, mc.Path, base, o, t, fmt.Sprintf("%x", theirs.Created)); err != nil {
+			return errors.Join(fmt.Errorf("failed to write conflict of %s", mc.Path), err)
+		}
+	}
+	if err := state.Save(); err != nil {
+		return err
+	}
+	return &ConflictError{Operation: state.Operation, Paths: state.Unresolved()}
+}
+
+// writeConflict writes a path both sides changed to the working tree. Text
+// files are merged line by line and written with conflict markers where
+// needed, a clean merge is no conflict. Other files keep our version and
+// both versions are written next to them. Both versions are kept in the
+// conflict state for scribe resolve.
+func (r *Remote) writeConflict(state *history.ConflictState, p string, base, ours, theirs conflictSide, theirsName string) error {
+	absPath, err := r.workingPath(p)
+	if err != nil {
+		return err
+	}
+	for _, s := range []conflictSide{ours, theirs} {
+		if s.content == "" {
+			continue
+		}
+		if err := copyFile(s.content, state.ContentPath(s.file.Hash), 0644); err != nil {
+			return errors.Join(errors.New("failed to keep conflicting version"), err)
+		}
+	}
+
+	cf := history.ConflictFile{Path: p, Ours: ours.file, Theirs: theirs.file}
+	if merged, conflicted, ok, err := mergeText(base, ours, theirs, theirsName); err != nil {
+		return err
+	} else if ok {
+		if err := os.WriteFile(absPath, merged, 0644); err != nil {
+			return err
+		}
+		if ours.file.Mode != 0 && runtime.GOOS != "windows" {
+			if err := os.Chmod(absPath, ours.file.Mode); err != nil {
+				return err
+			}
+		}
+		if !conflicted {
+			return nil
+		}
+		cf.Markers = true
+		state.Files = append(state.Files, cf)
+		return nil
+	}
+
+	if ours.file != nil {
+		if err := placeFile(absPath, *ours.file, ours.content); err != nil {
+			return err
+		}
+	} else if err := os.RemoveAll(absPath); err != nil {
+		return err
+	}
+	for _, side := range []struct {
+		conflictSide
+		suffix string
+	}{{ours, SuffixMine}, {theirs, SuffixTheirs}} {
+		if side.content == "" {
+			continue
+		}
+		if err := placeFile(absPath+side.suffix, *side.file, side.content); err != nil {
+			return err
+		}
+	}
+	state.Files = append(state.Files, cf)
+	return nil
+}
+
+// mergeText merges both sides line by line if they are text files. It
+// reports false if they are not.
+func mergeText(base, ours, theirs conflictSide, theirsName string) ([]byte, bool, bool, error) {
+	for _, s := range []conflictSide{ours, theirs} {
+		if s.file == nil || s.file.Type != history.FileTypeRegular || s.file.Size > maxMergeSize {
+			return nil, false, false, nil
+		}
+	}
+	var text [3][]byte
+	for i, s := range []conflictSide{base, ours, theirs} {
+		if s.file == nil || s.file.Type != history.FileTypeRegular {
+			continue
+		}
+		b, err := os.ReadFile(s.content)
+		if err != nil {
+			return nil, false, false, err
+		}
+		if diff.IsBinary(b) {
+			return nil, false, false, nil
+		}
+		text[i] = b
+	}
+	lines, conflicted := diff.Merge3(diff.SplitLines(string(text[0])), diff.SplitLines(string(text[1])), diff.SplitLines(string(text[2])), "mine", theirsName)
+	return []byte(strings.Join(lines, "")), conflicted, true, nil
+}
+
+// Resolve settles the conflict of a path: with ours or theirs that version
+// is written to the working tree, with merged the working tree file is
+// taken as it is, which must not contain conflict markers unless force is
+// set. The files written next to it are removed.
+func (r *Remote) Resolve(state *history.ConflictState, p string, how string, force bool) error {
+	cf, ok := state.File(p)
+	if !ok {
+		return fmt.Errorf("%s is not in conflict", p)
+	}
+	absPath, err := r.workingPath(p)
+	if err != nil {
+		return err
+	}
+
+	var take *history.CommitFile
+	switch how {
+	case ResolveOurs:
+		take = cf.Ours
+	case ResolveTheirs:
+		take = cf.Theirs
+	case ResolveMerged:
+		if cf.Markers && !force {
+			if has, err := hasConflictMarkers(absPath); err != nil {
+				return err
+			} else if has {
+				return fmt.Errorf("%s still contains conflict markers, use --force to take it anyway", p)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown resolution %q", how)
+	}
+
+	if how != ResolveMerged {
+		if take == nil {
+			cur, err := r.Config.CurrentCommit()
+			if err != nil {
+				return errors.Join(errors.New("failed to get current commit"), err)
+			}
+			if err := r.removeLocal(p, cur); err != nil {
+				return err
+			}
+		} else if err := placeFile(absPath, *take, state.ContentPath(take.Hash)); err != nil {
+			return errors.Join(fmt.Errorf("failed to write %s", p), err)
+		}
+	}
+
+	for _, suffix := range []string{SuffixMine, SuffixTheirs} {
+		if err := os.Remove(absPath + suffix); err != nil && !os.IsNotExist(err) {
+			return errors.Join(fmt.Errorf("failed to remove %s", p+suffix), err)
+		}
+	}
+	cf.Resolved = true
+	return state.Save()
+}
+
+func hasConflictMarkers(absPath string) (bool, error) {
+	f, err := os.Open(absPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, maxMergeSize)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, diff.MarkerOurs) || strings.HasPrefix(line, diff.MarkerTheirs) || line == diff.MarkerSep {
+			return true, nil
+		}
+	}
+	return false, sc.Err()
+}
+
+// ContinueCheckout finishes a checkout or rebase once all conflicts are
+// resolved. The stash of the local changes is dropped, they are in the
+// working tree now.
+func (r *Remote) ContinueCheckout(state *history.ConflictState) error {
+	if unresolved := state.Unresolved(); len(unresolved) != 0 {
+		return &ConflictError{Operation: state.Operation, Paths: unresolved}
+	}
+	if s, ok, err := findStash(state.Stash); err != nil {
+		return err
+	} else if ok {
+		if err := s.Drop(); err != nil {
+			return err
+		}
+	}
+	return state.Remove()
+}
+
+// AbortCheckout returns to the commit and the local changes from before the
+// interrupted checkout or rebase.
+func (r *Remote) AbortCheckout(state *history.ConflictState) error {
+	to, err := r.Config.CurrentCommit()
+	if err != nil {
+		return errors.Join(errors.New("failed to get current commit"), err)
+	}
+	from, err := history.Load(state.From)
+	if err != nil {
+		return errors.Join(errors.New("failed to load previous commit"), err)
+	}
+	s, ok, err := findStash(state.Stash)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Reset(to); err != nil {
+		return errors.Join(errors.New("failed to reset working tree"), err)
+	}
+	if err := r.CheckoutCommit(from); err != nil {
+		return errors.Join(errors.New("failed to checkout previous commit"), err)
+	}
+	if ok {
+		if _, err := r.StashApply(s); err != nil {
+			return errors.Join(errors.New("failed to reapply local changes, they are kept as a stash"), err)
+		}
+		if err := s.Drop(); err != nil {
+			return err
+		}
+	}
+	if state.Local != 0 {
+		// the rebased commit holds the same files as base and stash
+		r.Config.Commit = state.Local
+		r.Config.Detached = true
+		if err := r.Config.Save(); err != nil {
+			return errors.Join(errors.New("failed to save config"), err)
+		}
+	}
+	return state.Remove()
+}
+
+// ContinueMerge commits the resolved working tree as the merge commit of the
+// interrupted merge.
+func (r *Remote) ContinueMerge(state *history.ConflictState, msg string) error {
+	if unresolved := state.Unresolved(); len(unresolved) != 0 {
+		return &ConflictError{Operation: state.Operation, Paths: unresolved}
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("Merge %x into %s", state.To, r.Config.CurrentBranch())
+	}
+	if err := r.commitWorkingTree(&history.Commit{
+		Parents: []int64{state.From, state.To},
+		Message: msg,
+		Ignore:  r.Config.Ignore,
+	}, nil, PushMerge); err != nil {
+		return err
+	}
+	return state.Remove()
+}
+
+// AbortMerge discards the interrupted merge and restores the checked out
+// commit.
+func (r *Remote) AbortMerge(state *history.ConflictState) error {
+	ours, err := history.Load(state.From)
+	if err != nil {
+		return errors.Join(errors.New("failed to load commit"), err)
+	}
+	if err := r.Reset(ours); err != nil {
+		return errors.Join(errors.New("failed to reset working tree"), err)
+	}
+	return state.Remove()
+}
+
+func findStash(id int64) (*history.Stash, bool, error) {
+	stashes, err := history.Stashes()
+	if err != nil {
+		return nil, false, err
+	}
+	i := slices.IndexFunc(stashes, func(s *history.Stash) bool {
+		return s.Created == id
+	})
+	if i < 0 {
+		return nil, false, nil
+	}
+	return stashes[i], true, nil
+}
@@ -0,0 +1,119 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"scribe/internal/crypt"
+
+	"github.com/charmbracelet/huh"
+)
+
+// Keys holds the repository key, wrapped once for every passphrase and
+// recipient it is shared with. It is stored in the KEYS file at the
+// repository root.
+type Keys struct {
+	Stanzas []crypt.Stanza `yaml:"stanzas"`
+}
+
+const FileKeys = "KEYS"
+
+// Encrypted reports whether objects and commits are encrypted client-side.
+func (r *Remote) Encrypted() bool {
+	return r.Format != nil && len(r.Format.Encryption) != 0
+}
+
+// CheckEncryption refuses a remote that says it is unencrypted while a
+// repository key is known for it. The FORMAT file is controlled by the
+// server, which could drop the encryption from it to receive plaintext.
+func (r *Remote) CheckEncryption() error {
+	if r.Config.Key != nil && !r.Encrypted() {
+		return errors.New("a repository key is known for this repository but the remote says it is not encrypted, refusing to continue")
+	}
+	return nil
+}
+
+func (r *Remote) ReadKeys() (*Keys, error) {
+	k := &Keys{}
+	if exists, err := r.readYaml(FileKeys, k); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.New("repository is encrypted but has no " + FileKeys + " file")
+	}
+	return k, nil
+}
+
+// InitEncryption creates a new repository key protected by the passphrase
+// and marks the repository as encrypted. It has to be called on an empty
+// repository before the initial commit.
+func (r *Remote) InitEncryption(passphrase string) error {
+	key, err := crypt.NewKey()
+	if err != nil {
+		return errors.Join(errors.New("failed to generate repository key"), err)
+	}
+	s, err := key.WrapPassphrase(passphrase)
+	if err != nil {
+		return errors.Join(errors.New("failed to wrap repository key"), err)
+	}
+	if err := r.writeYaml(FileKeys, Keys{Stanzas: []crypt.Stanza{s}}); err != nil {
+		return err
+	}
+	if err := r.WriteFormat(EncryptedFormat()); err != nil {
+		return err
+	}
+	r.Config.Key = key
+	return nil
+}
+
+// Unlock makes the repository key available for encrypted repositories.
+// The identity file is tried first, then the user is asked for a passphrase.
+// The key is kept in the keyring afterwards.
+func (r *Remote) Unlock() error {
+	if !r.Encrypted() || r.Config.Key != nil {
+		return nil
+	}
+
+	keys, err := r.ReadKeys()
+	if err != nil {
+		return err
+	}
+
+	if ip, err := r.Config.IdentityPath(); err == nil {
+		if b, err := os.ReadFile(ip); err == nil {
+			id, err := crypt.ParseIdentity(string(b))
+			if err != nil {
+				return errors.Join(fmt.Errorf("failed to parse identity %s", ip), err)
+			}
+			if key, err := id.Unwrap(keys.Stanzas); err == nil {
+				r.Config.Key = key
+				return r.Config.SaveKey()
+			}
+		}
+	}
+
+	var passphrase string
+	if err := huh.NewForm(huh.NewGroup(
+		huh.NewInput().
+			Title("Passphrase for encrypted repository").
+			EchoMode(huh.EchoModePassword).
+			Value(&passphrase),
+	)).Run(); err != nil {
+		return err
+	}
+	key, err := crypt.UnwrapPassphrase(keys.Stanzas, passphrase)
+	if err != nil {
+		return err
+	}
+	r.Config.Key = key
+	return r.Config.SaveKey()
+}
+
+// ShareKey wraps the repository key for another recipient or passphrase.
+func (r *Remote) ShareKey(s crypt.Stanza) error {
+	keys, err := r.ReadKeys()
+	if err != nil {
+		return err
+	}
+	keys.Stanzas = append(keys.Stanzas, s)
+	return r.writeYaml(FileKeys, keys)
+}
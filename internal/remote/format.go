@@ -0,0 +1,101 @@
+package remote
+
+import (
+	"fmt"
+)
+
+// Format describes the layout of a remote repository. It is stored in the
+// FORMAT file at the repository root.
+type Format struct {
+	Version     int    `yaml:"version"`
+	Hash        string `yaml:"hash"`
+	Compression string `yaml:"compression"`
+	Commits     string `yaml:"commits"`
+	Encryption  string `yaml:"encryption,omitempty"`
+}
+
+const FileFormat = "FORMAT"
+
+const (
+	// FormatVersionLegacy is the layout of repositories without a FORMAT
+	// file: commits list their files inline and have no parents.
+	FormatVersionLegacy = 1
+	// FormatVersion is the layout written by this version of scribe.
+	FormatVersion = 2
+)
+
+func CurrentFormat() Format {
+	return Format{
+		Version:     FormatVersion,
+		Hash:        "sha256",
+		Compression: "gzip",
+		Commits:     "tree",
+	}
+}
+
+// EncryptedFormat is the current format of repositories with client-side
+// encryption. Objects are hashed with a keyed hash.
+func EncryptedFormat() Format {
+	f := CurrentFormat()
+	f.Hash = "hmac-sha256"
+	f.Encryption = "chacha20poly1305"
+	return f
+}
+
+func (f Format) supported() bool {
+	if len(f.Encryption) != 0 {
+		return f == EncryptedFormat()
+	}
+	return f == CurrentFormat()
+}
+
+// ReadFormat reads the format descriptor of the remote repository.
+// Repositories without a descriptor are either empty or use the legacy layout.
+func (r *Remote) ReadFormat() (*Format, error) {
+	f := &Format{}
+	if exists, err := r.readYaml(FileFormat, f); err != nil {
+		return nil, err
+	} else if exists {
+		return f, nil
+	}
+
+	if empty, err := r.RepoIsEmpty(); err != nil {
+		return nil, err
+	} else if empty {
+		return nil, nil
+	}
+	return &Format{Version: FormatVersionLegacy, Hash: "sha256", Compression: "gzip", Commits: "files"}, nil
+}
+
+func (r *Remote) WriteFormat(f Format) error {
+	if err := r.writeYaml(FileFormat, f); err != nil {
+		return err
+	}
+	r.Format = &f
+	return nil
+}
+
+// CheckFormat refuses repositories whose layout this version of scribe
+// can't read or write. Empty repositories are accepted, init writes the
+// format file.
+func (r *Remote) CheckFormat() error {
+	f, err := r.ReadFormat()
+	if err != nil {
+		return err
+	}
+	r.Format = f
+	if f == nil {
+		return nil
+	}
+
+	current := CurrentFormat()
+	switch {
+	case f.Version < current.Version:
+		return fmt.Errorf("remote repository uses format version %d, this version of scribe requires %d; run scribe migrate to upgrade it", f.Version, current.Version)
+	case f.Version > current.Version:
+		return fmt.Errorf("remote repository uses format version %d, this version of scribe only supports up to %d; update scribe", f.Version, current.Version)
+	case !f.supported():
+		return fmt.Errorf("remote repository format (hash %s, compression %s, commits %s, encryption %s) is not supported", f.Hash, f.Compression, f.Commits, f.Encryption)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+	"scribe/internal/ignore"
+)
+
+// Stage uploads the local versions of the selected paths and records them in
+// the index. Tracked paths that are gone locally are staged as deletions.
+// Paths that match the current commit again are dropped from the index.
+func (r *Remote) Stage(idx *history.Index, patterns []string) ([]string, error) {
+	prev, err := r.Config.CurrentCommit()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to get current commit"), err)
+	}
+
+	localWd := r.LocalWD()
+	matched := false
+	var staged []string
+	scratch := &history.Commit{}
+	if err := ignore.Walk(r.Config, localWd, func(repoPath string, absPath string, d fs.DirEntry) error {
+		if !diff.MatchPath(repoPath, patterns) {
+			return nil
+		}
+		matched = true
+
+		if pf, ok := prev.File(repoPath); ok {
+			if same, err := diff.LocalSame(r.Config, absPath, pf); err != nil {
+				return err
+			} else if same {
+				idx.Unstage(func(p string) bool { return p == repoPath })
+				return nil
+			}
+		}
+		if e, ok := idx.Get(repoPath); ok && !e.Deleted {
+			if same, err := diff.LocalSame(r.Config, absPath, e.CommitFile); err != nil {
+				return err
+			} else if same {
+				return nil
+			}
+		}
+
+		scratch.Files = scratch.Files[:0]
+		if err := r.CommitPath(absPath, repoPath, d, prev, scratch); err != nil {
+			return err
+		}
+		idx.Stage(scratch.Files[0])
+		staged = append(staged, repoPath)
+		return nil
+	}); err != nil {
+		return staged, errors.Join(fmt.Errorf("failed to walk repo dir %s", localWd), err)
+	}
+
+	for _, f := range prev.Files {
+		if !diff.MatchPath(f.Path, patterns) {
+			continue
+		}
+		matched = true
+		if _, err := os.Lstat(filepath.Join(localWd, filepath.FromSlash(f.Path))); !os.IsNotExist(err) {
+			continue
+		}
+		if e, ok := idx.Get(f.Path); !ok || !e.Deleted {
+			idx.StageDelete(f.Path)
+			staged = append(staged, f.Path)
+		}
+	}
+
+	// new files that were staged and deleted again are no change at all
+	idx.Unstage(func(p string) bool {
+		if _, ok := prev.File(p); ok || !diff.MatchPath(p, patterns) {
+			return false
+		}
+		_, err := os.Lstat(filepath.Join(localWd, filepath.FromSlash(p)))
+		return os.IsNotExist(err)
+	})
+
+	if !matched {
+		return nil, fmt.Errorf("no files match %v", patterns)
+	}
+	return staged, nil
+}
+
+// StageRemove stages the deletion of the selected tracked paths and, unless
+// cached is set, deletes them from the working tree. Selected files that were
+// only staged are dropped from the index and kept in the working tree.
+func (r *Remote) StageRemove(idx *history.Index, patterns []string, cached bool) ([]string, error) {
+	prev, err := r.Config.CurrentCommit()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to get current commit"), err)
+	}
+
+	var removed []string
+	for _, f := range prev.Files {
+		if diff.MatchPath(f.Path, patterns) {
+			idx.StageDelete(f.Path)
+			removed = append(removed, f.Path)
+		}
+	}
+	unstaged := idx.Unstage(func(p string) bool {
+		_, tracked := prev.File(p)
+		return !tracked && diff.MatchPath(p, patterns)
+	})
+	if len(removed) == 0 && len(unstaged) == 0 {
+		return nil, fmt.Errorf("no tracked files match %v", patterns)
+	}
+
+	if !cached {
+		for _, p := range removed {
+			if err := r.removeLocal(p, prev); err != nil {
+				return removed, err
+			}
+		}
+	}
+	return append(removed, unstaged...), nil
+}
+
+// CommitStaged publishes the current commit with the staged changes applied
+// as the new HEAD and clears the index once the commit is uploaded. push
+// decides what happens when the remote head moved on, see publish.
+func (r *Remote) CommitStaged(msg string, idx *history.Index, push Push) error {
+	prev, err := r.Config.CurrentCommit()
+	if err != nil {
+		return errors.Join(errors.New("failed to get current commit"), err)
+	}
+
+	commit := &history.Commit{
+		Parents: []int64{prev.Created},
+		Message: msg,
+		Ignore:  r.Config.Ignore,
+		Files:   idx.Apply(prev),
+	}
+	err = r.publishLocal(commit, push)
+	if err != nil && r.Config.Commit != commit.Created {
+		return err
+	}
+
+	idx.Entries = nil
+	if err := idx.Save(); err != nil {
+		return errors.Join(errors.New("failed to clear index"), err)
+	}
+	return err
+}
@@ -0,0 +1,278 @@
+package remote
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"scribe/internal/util"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileLock is the lock file that guards updates of HEAD and the other refs.
+// It holds the lease of its owner unencrypted, so it can be created
+// exclusively.
+const FileLock = "LOCK"
+
+const (
+	// lockLease is how long a lock is held at most before others may
+	// take it over.
+	lockLease = 2 * time.Minute
+	// lockWait is how long to wait for a lock held by someone else.
+	lockWait = 30 * time.Second
+	// lockRetry is the pause between attempts to take the lock.
+	lockRetry = 500 * time.Millisecond
+)
+
+// Lease records who holds the remote lock and until when.
+type Lease struct {
+	Owner   string `yaml:"owner"`
+	Host    string `yaml:"host"`
+	Token   string `yaml:"token"`
+	Created int64  `yaml:"created"`
+	Expires int64  `yaml:"expires"`
+	// modified is when the lock file was written, it stands in for the
+	// expiry of a lock file that was left empty or half written.
+	modified time.Time `yaml:"-"`
+}
+
+// Expired reports whether the lease ran out. A lock file without an expiry
+// runs out a lease after it was written.
+func (l *Lease) Expired() bool {
+	if l.Expires == 0 {
+		return !l.modified.IsZero() && time.Now().After(l.modified.Add(lockLease))
+	}
+	return time.Now().Unix() >= l.Expires
+}
+
+func (l *Lease) String() string {
+	if l.Expires == 0 {
+		return "an unknown owner"
+	}
+	return fmt.Sprintf("%s on %s since %s until %s", l.Owner, l.Host, util.FormatTime(l.Created), util.FormatTime(l.Expires))
+}
+
+// LockedError is returned when the remote lock is held by someone else for
+// longer than we wait.
+type LockedError struct {
+	Lease *Lease
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("remote is locked by %s, run scribe unlock --force if that lock is stale", e.Lease)
+}
+
+// newToken returns a random token to tell leases and temporary files apart.
+func newToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// readLease reads the lease in the remote file p, it reports false if the
+// file does not exist.
+func (r *Remote) readLease(p string) (*Lease, bool, error) {
+	f, err := r.SftpClient.Open(path.Join(r.WD, p))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, errors.Join(errors.New("failed to open lock file"), err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false, errors.Join(errors.New("failed to stat lock file"), err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, false, errors.Join(errors.New("failed to read lock file"), err)
+	}
+	l := &Lease{}
+	if err := yaml.Unmarshal(data, l); err != nil {
+		// a client crashed while writing it
+		l = &Lease{}
+	}
+	l.modified = fi.ModTime()
+	return l, true, nil
+}
+
+// ReadLock returns the lease of the remote lock, it reports false if the
+// remote is not locked.
+func (r *Remote) ReadLock() (*Lease, bool, error) {
+	return r.readLease(FileLock)
+}
+
+// WithLock runs fn while holding the remote lock. A lock held by someone
+// else is waited for a while, an expired one is taken over. Nested calls
+// run under the lock that is already held.
+func (r *Remote) WithLock(fn func() error) error {
+	if r.lease != nil {
+		return fn()
+	}
+	if err := r.acquireLock(); err != nil {
+		return err
+	}
+	err := fn()
+	if rerr := r.releaseLock(); rerr != nil {
+		err = errors.Join(err, errors.Join(errors.New("failed to release remote lock"), rerr))
+	}
+	return err
+}
+
+func (r *Remote) acquireLock() error {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	token, err := newToken()
+	if err != nil {
+		return errors.Join(errors.New("failed to create lock token"), err)
+	}
+
+	deadline := time.Now().Add(lockWait)
+	waiting := false
+	for {
+		now := time.Now()
+		l := &Lease{Owner: r.Config.User, Host: host, Token: token, Created: now.Unix(), Expires: now.Add(lockLease).Unix()}
+		if created, err := r.createLock(l); err != nil {
+			return err
+		} else if created {
+			r.lease = l
+			return nil
+		}
+
+		held, ok, err := r.ReadLock()
+		if err != nil {
+			return err
+		}
+		switch {
+		case !ok:
+			// released meanwhile
+		case held.Expired():
+			log.Printf("take over the expired lock of %s\n", held)
+			if err := r.breakLock(held); err != nil {
+				return errors.Join(errors.New("failed to take over expired lock"), err)
+			}
+		case now.After(deadline):
+			return &LockedError{Lease: held}
+		default:
+			if !waiting {
+				log.Printf("wait for the remote lock of %s\n", held)
+				waiting = true
+			}
+			time.Sleep(lockRetry)
+		}
+	}
+}
+
+// createLock creates the lock file with lease l unless it exists already.
+func (r *Remote) createLock(l *Lease) (bool, error) {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return false, errors.Join(errors.New("failed to encode lease"), err)
+	}
+
+	lp := path.Join(r.WD, FileLock)
+	f, err := r.SftpClient.OpenFile(lp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		// SFTP servers don't tell an existing file apart from other failures
+		if _, serr := r.SftpClient.Stat(lp); serr == nil {
+			return false, nil
+		}
+		return false, errors.Join(errors.New("failed to create lock file"), err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = r.SftpClient.Remove(lp)
+		return false, errors.Join(errors.New("failed to write lock file"), err)
+	}
+	if err := f.Close(); err != nil {
+		_ = r.SftpClient.Remove(lp)
+		return false, errors.Join(errors.New("failed to write lock file"), err)
+	}
+	return true, nil
+}
+
+// releaseLock removes the lock file if it still holds our lease. It is moved
+// away before its lease is checked, so a lock someone else created right
+// after reading it is never removed. Such a lock is linked back, which
+// leaves an even newer one alone.
+func (r *Remote) releaseLock() error {
+	ours := r.lease
+	r.lease = nil
+	released := "." + FileLock + ".release-" + ours.Token
+	if err := r.SftpClient.PosixRename(path.Join(r.WD, FileLock), path.Join(r.WD, released)); err != nil {
+		if _, serr := r.SftpClient.Stat(path.Join(r.WD, FileLock)); os.IsNotExist(serr) {
+			log.Println("the remote lock was taken over by someone else in the meantime")
+			return nil
+		}
+		return errors.Join(errors.New("failed to move lock file"), err)
+	}
+
+	held, _, err := r.readLease(released)
+	if err != nil {
+		return err
+	}
+	if held.Token != ours.Token {
+		log.Println("the remote lock was taken over by someone else in the meantime")
+		if err := r.SftpClient.Link(path.Join(r.WD, released), path.Join(r.WD, FileLock)); err != nil {
+			log.Printf("could not restore the lock of %s: %v\n", held, err)
+		}
+	}
+	return r.SftpClient.Remove(path.Join(r.WD, released))
+}
+
+// breakLock removes the lock file if it still holds lease l. The file is
+// moved away first, so of several clients breaking the same lease only one
+// succeeds. Whether it did or not, the lock has to be acquired afterwards.
+func (r *Remote) breakLock(l *Lease) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	stale := "." + FileLock + ".stale-" + token
+	if err := r.SftpClient.PosixRename(path.Join(r.WD, FileLock), path.Join(r.WD, stale)); err != nil {
+		if _, serr := r.SftpClient.Stat(path.Join(r.WD, FileLock)); os.IsNotExist(serr) {
+			// someone else broke it
+			return nil
+		}
+		return errors.Join(errors.New("failed to move lock file"), err)
+	}
+
+	moved, _, err := r.readLease(stale)
+	if err != nil {
+		return err
+	}
+	if moved.Token != l.Token {
+		// it was taken over between reading and moving it, so we lost the
+		// race. Renaming it back could overwrite a lock created meanwhile,
+		// a hard link only puts it back if there is none. Either way the
+		// caller tries to acquire the lock again.
+		if err := r.SftpClient.Link(path.Join(r.WD, stale), path.Join(r.WD, FileLock)); err != nil {
+			log.Printf("could not restore the lock of %s: %v\n", moved, err)
+		}
+	}
+	return r.SftpClient.Remove(path.Join(r.WD, stale))
+}
+
+// BreakLock removes the remote lock whoever holds it and returns the lease
+// it held. Only meant for stale locks of clients that crashed.
+func (r *Remote) BreakLock() (*Lease, bool, error) {
+	l, ok, err := r.ReadLock()
+	if err != nil || !ok {
+		return l, ok, err
+	}
+	if err := r.SftpClient.Remove(path.Join(r.WD, FileLock)); err != nil && !os.IsNotExist(err) {
+		return nil, false, errors.Join(errors.New("failed to remove lock file"), err)
+	}
+	return l, true, nil
+}
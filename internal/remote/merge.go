@@ -0,0 +1,237 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+	"slices"
+	"strings"
+)
+
+// maxMergeSize is the size above which files are not merged line by line.
+const maxMergeSize = 8 * 1024 * 1024
+
+// MergeConflict is a path both sides changed in ways that can't be combined.
+// Missing versions are nil. Merged holds the text with conflict markers, it
+// is nil for binary files and changes of the file type.
+type MergeConflict struct {
+	Path   string
+	Base   *history.CommitFile
+	Ours   *history.CommitFile
+	Theirs *history.CommitFile
+	Merged []byte
+}
+
+// MergeResult is the outcome of merging two commits.
+type MergeResult struct {
+	Base      *history.Commit
+	Files     []history.CommitFile
+	Conflicts []MergeConflict
+}
+
+// MergeConflictError is returned when commits can't be merged automatically.
+type MergeConflictError struct {
+	Conflicts []MergeConflict
+}
+
+func (e *MergeConflictError) Error() string {
+	paths := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		paths[i] = c.Path
+	}
+	return fmt.Sprintf("%d files conflict: %s", len(paths), strings.Join(paths, ", "))
+}
+
+// MergeCommits merges theirs into ours against their newest common ancestor.
+// Files changed on one side only are taken from that side, text files
+// changed on both sides are merged line by line. Merged contents are
+// uploaded, so the resulting files can be committed right away.
+// Commits have to be pulled beforehand.
+func (r *Remote) MergeCommits(ours, theirs *history.Commit) (*MergeResult, error) {
+	h, err := history.LoadAll()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to load history"), err)
+	}
+	result := &MergeResult{Base: &history.Commit{}}
+	if b, ok := h.MergeBase(ours.Created, theirs.Created); ok {
+		if result.Base, err = history.Load(b.Created); err != nil {
+			return nil, errors.Join(errors.New("failed to load merge base"), err)
+		}
+	}
+	for _, c := range []*history.Commit{ours, theirs} {
+		if err := c.LoadFiles(); err != nil {
+			return nil, err
+		}
+	}
+
+	paths := make(map[string]struct{})
+	for _, c := range []*history.Commit{result.Base, ours, theirs} {
+		for _, f := range c.Files {
+			paths[f.Path] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	slices.Sort(sorted)
+
+	oursName := fmt.Sprintf("%x", ours.Created)
+	theirsName := fmt.Sprintf("%x", theirs.Created)
+	for _, p := range sorted {
+		b, o, t := fileOf(result.Base, p), fileOf(ours, p), fileOf(theirs, p)
+		var take *history.CommitFile
+		switch {
+		case sameFile(o, t), sameFile(b, t):
+			take = o
+		case sameFile(b, o):
+			take = t
+		default:
+			merged, conflict, err := r.mergeFile(p, b, o, t, oursName, theirsName)
+			if err != nil {
+				return nil, errors.Join(fmt.Errorf("failed to merge %s", p), err)
+			}
+			if conflict != nil {
+				result.Conflicts = append(result.Conflicts, *conflict)
+				continue
+			}
+			take = merged
+		}
+		if take != nil {
+			result.Files = append(result.Files, *take)
+		}
+	}
+	return result, nil
+}
+
+func fileOf(c *history.Commit, p string) *history.CommitFile {
+	if f, ok := c.File(p); ok {
+		return &f
+	}
+	return nil
+}
+
+func sameFile(a, b *history.CommitFile) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Same(*b)
+}
+
+// mergeFile merges a file both sides changed. It returns either the merged
+// file or the conflict.
+func (r *Remote) mergeFile(p string, b, o, t *history.CommitFile, oursName, theirsName string) (*history.CommitFile, *MergeConflict, error) {
+	conflict := &MergeConflict{Path: p, Base: b, Ours: o, Theirs: t}
+	if o == nil || t == nil || o.Type != history.FileTypeRegular || t.Type != history.FileTypeRegular ||
+		o.Size > maxMergeSize || t.Size > maxMergeSize {
+		return nil, conflict, nil
+	}
+
+	var base []byte
+	if b != nil && b.Type == history.FileTypeRegular {
+		var err error
+		if base, err = r.ObjectContent(*b); err != nil {
+			return nil, nil, err
+		}
+	}
+	ours, err := r.ObjectContent(*o)
+	if err != nil {
+		return nil, nil, err
+	}
+	theirs, err := r.ObjectContent(*t)
+	if err != nil {
+		return nil, nil, err
+	}
+	if diff.IsBinary(base) || diff.IsBinary(ours) || diff.IsBinary(theirs) {
+		return nil, conflict, nil
+	}
+
+	lines, conflicted := diff.Merge3(diff.SplitLines(string(base)), diff.SplitLines(string(ours)), diff.SplitLines(string(theirs)), oursName, theirsName)
+	merged := []byte(strings.Join(lines, ""))
+	if conflicted {
+		conflict.Merged = merged
+		return nil, conflict, nil
+	}
+
+	cf := history.CommitFile{Path: p, Mode: o.Mode, Size: int64(len(merged))}
+	if b != nil && o.Mode == b.Mode {
+		cf.Mode = t.Mode
+	}
+	if cf.Hash, err = r.Config.HashReader(bytes.NewReader(merged)); err != nil {
+		return nil, nil, errors.Join(errors.New("failed to hash merged content"), err)
+	}
+	if has, err := r.HasObject(cf.Hash); err != nil {
+		return nil, nil, errors.Join(errors.New("failed to check object existence"), err)
+	} else if !has {
+		if err := r.WriteObject(bytes.NewReader(merged), cf.Hash); err != nil {
+			return nil, nil, errors.Join(errors.New("failed to write merged object"), err)
+		}
+	}
+	return &cf, nil, nil
+}
+
+// Merge merges theirs into the checked out commit, publishes the result on
+// the checked out branch and checks it out. If the checked out commit
+// already contains theirs nothing happens, if theirs contains it, theirs is
+// checked out without a merge commit. Conflicts are written to the working
+// tree and recorded, see ContinueMerge. Commits have to be pulled beforehand.
+func (r *Remote) Merge(theirs *history.Commit, msg string) (*history.Commit, error) {
+	ours, err := r.Config.CurrentCommit()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to get current commit"), err)
+	}
+	h, err := history.LoadAll()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to load history"), err)
+	}
+	if h.IsAncestor(theirs.Created, ours.Created) {
+		return ours, nil
+	}
+
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to get head commit"), err)
+	}
+
+	next := theirs
+	if h.IsAncestor(ours.Created, theirs.Created) {
+		log.Printf("fast-forward to commit %x\n", theirs.Created)
+		if _, moved, err := r.advanceHead(theirs, false, false); err != nil {
+			return nil, err
+		} else if moved {
+			head = theirs
+		}
+	} else {
+		result, err := r.MergeCommits(ours, theirs)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Conflicts) != 0 {
+			return nil, r.mergeWithConflicts(ours, theirs, result)
+		}
+		if msg == "" {
+			msg = fmt.Sprintf("Merge %x into %s", theirs.Created, r.Config.CurrentBranch())
+		}
+		if next, err = r.publish(&history.Commit{
+			Parents: []int64{ours.Created, theirs.Created},
+			Message: msg,
+			Ignore:  ours.Ignore,
+			Files:   result.Files,
+		}, PushMerge); err != nil {
+			return nil, err
+		}
+		head = next
+	}
+
+	if err := r.CheckoutCommit(next); err != nil {
+		return nil, errors.Join(errors.New("failed to checkout commit"), err)
+	}
+	r.Config.Detached = next.Created != head.Created
+	if err := r.Config.Save(); err != nil {
+		return nil, errors.Join(errors.New("failed to save config"), err)
+	}
+	return next, nil
+}
@@ -0,0 +1,203 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"scribe/internal/history"
+	"slices"
+)
+
+// Migration upgrades a remote repository from one format version to the next.
+// The upgrade is applied commit by commit so it can be resumed.
+type Migration struct {
+	From int
+	To   int
+	// Commit upgrades a single commit and describes what it changed.
+	// Nothing is written when dryRun is set.
+	Commit func(r *Remote, c *history.Commit, dryRun bool) ([]string, error)
+}
+
+// MigrateJournal records the progress of a running migration on the remote.
+type MigrateJournal struct {
+	From int     `yaml:"from"`
+	To   int     `yaml:"to"`
+	Done []int64 `yaml:"done"`
+}
+
+const FileMigrateJournal = "MIGRATE"
+
+var migrations = []Migration{
+	{From: 1, To: 2, Commit: migrateTrees},
+}
+
+// migrateTrees records the implicit parent of legacy commits and moves
+// their inline file lists into tree objects.
+func migrateTrees(r *Remote, c *history.Commit, dryRun bool) ([]string, error) {
+	var changes []string
+	for _, p := range c.Parents {
+		changes = append(changes, fmt.Sprintf("record parent %x", p))
+	}
+	if len(c.Tree) == 0 {
+		changes = append(changes, fmt.Sprintf("move %d files into tree objects", len(c.Files)))
+		if !dryRun {
+			if err := r.WriteTrees(c); err != nil {
+				return nil, errors.Join(errors.New("failed to write trees"), err)
+			}
+		}
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	if err := c.Save(); err != nil {
+		return nil, errors.Join(errors.New("failed to save commit"), err)
+	}
+	cf, err := c.Open()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to open commit file"), err)
+	}
+	defer cf.Close()
+	if err := r.WriteCommit(cf, c); err != nil {
+		return nil, errors.Join(errors.New("failed to write commit"), err)
+	}
+	return changes, nil
+}
+
+// Migrate upgrades the remote repository in place to the current format.
+// Progress is journaled on the remote, an interrupted migration continues
+// where it stopped when run again. Each commit and the final format change
+// are written under the remote lock.
+func (r *Remote) Migrate(dryRun bool, report func(msg string)) error {
+	f, err := r.ReadFormat()
+	if err != nil {
+		return errors.Join(errors.New("failed to read format"), err)
+	}
+	if f == nil {
+		return errors.New("remote repository is empty, nothing to migrate")
+	}
+	if f.Version > FormatVersion {
+		return fmt.Errorf("remote repository uses format version %d, this version of scribe only supports up to %d", f.Version, FormatVersion)
+	}
+	if f.Version == FormatVersion {
+		report(fmt.Sprintf("remote repository already uses format version %d", f.Version))
+		return nil
+	}
+
+	for f.Version < FormatVersion {
+		i := slices.IndexFunc(migrations, func(m Migration) bool { return m.From == f.Version })
+		if i < 0 {
+			return fmt.Errorf("no migration from format version %d", f.Version)
+		}
+		m := migrations[i]
+
+		j, err := r.readMigrateJournal()
+		if err != nil {
+			return err
+		}
+		if j == nil {
+			j = &MigrateJournal{From: m.From, To: m.To}
+		} else if j.From != m.From || j.To != m.To {
+			return fmt.Errorf("found journal of an unfinished migration from %d to %d, expected %d to %d", j.From, j.To, m.From, m.To)
+		} else {
+			report(fmt.Sprintf("resume migration, %d commits already done", len(j.Done)))
+		}
+
+		report(fmt.Sprintf("migrate from format version %d to %d", m.From, m.To))
+
+		if err := r.PullCommits(); err != nil {
+			return errors.Join(errors.New("failed to pull commits"), err)
+		}
+		h, err := history.LoadAll()
+		if err != nil {
+			return errors.Join(errors.New("failed to load history"), err)
+		}
+
+		for _, c := range h {
+			if slices.Contains(j.Done, c.Created) {
+				continue
+			}
+			if dryRun {
+				changes, err := m.Commit(r, c, true)
+				if err != nil {
+					return errors.Join(fmt.Errorf("failed to migrate commit %x", c.Created), err)
+				}
+				for _, change := range changes {
+					report(fmt.Sprintf("commit %x: %s", c.Created, change))
+				}
+				continue
+			}
+			// every step holds the lock on its own, a long migration would
+			// outlive a single lease
+			if err := r.WithLock(func() error {
+				// another run may have migrated the commit meanwhile
+				if cur, err := r.readMigrateJournal(); err != nil {
+					return err
+				} else if cur != nil {
+					j = cur
+				}
+				if slices.Contains(j.Done, c.Created) {
+					return nil
+				}
+				changes, err := m.Commit(r, c, false)
+				if err != nil {
+					return errors.Join(fmt.Errorf("failed to migrate commit %x", c.Created), err)
+				}
+				for _, change := range changes {
+					report(fmt.Sprintf("commit %x: %s", c.Created, change))
+				}
+				j.Done = append(j.Done, c.Created)
+				return r.writeMigrateJournal(j)
+			}); err != nil {
+				return err
+			}
+		}
+
+		if dryRun {
+			report("dry run, nothing was written")
+			return nil
+		}
+
+		next := *f
+		next.Version = m.To
+		if m.To == FormatVersion {
+			next = CurrentFormat()
+		}
+		if err := r.WithLock(func() error {
+			cur, err := r.ReadFormat()
+			if err != nil {
+				return errors.Join(errors.New("failed to read format"), err)
+			}
+			if cur != nil && cur.Version != f.Version {
+				return fmt.Errorf("format version changed to %d during the migration", cur.Version)
+			}
+			if err := r.WriteFormat(next); err != nil {
+				return err
+			}
+			if err := r.SftpClient.Remove(path.Join(r.WD, FileMigrateJournal)); err != nil {
+				return errors.Join(errors.New("failed to remove migration journal"), err)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		f = &next
+	}
+
+	report(fmt.Sprintf("remote repository now uses format version %d", f.Version))
+	return nil
+}
+
+func (r *Remote) readMigrateJournal() (*MigrateJournal, error) {
+	j := &MigrateJournal{}
+	if exists, err := r.readYaml(FileMigrateJournal, j); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+	return j, nil
+}
+
+func (r *Remote) writeMigrateJournal(j *MigrateJournal) error {
+	return r.writeYaml(FileMigrateJournal, j)
+}
@@ -0,0 +1,223 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+	"scribe/internal/util"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DirLocks holds one file per locked path. They are named by the keyed hash
+// of the path, so encrypted repositories don't reveal it.
+const DirLocks = "locks"
+
+func (r *Remote) pathLockPath(p string) string {
+	h := r.Config.NewHash()
+	h.Write([]byte(p))
+	return path.Join(DirLocks, util.EncodeHash(h)+".yaml")
+}
+
+// LockedFilesError is returned when files are locked by someone else.
+type LockedFilesError struct {
+	Locks []history.PathLock
+}
+
+func (e *LockedFilesError) Error() string {
+	files := make([]string, 0, len(e.Locks))
+	for _, l := range e.Locks {
+		files = append(files, fmt.Sprintf("%s (%s)", l.Path, l.Owner))
+	}
+	return "locked by someone else: " + strings.Join(files, ", ")
+}
+
+// PathLocks reads the file locks from the remote and keeps a local copy of
+// them for scribe status.
+func (r *Remote) PathLocks() (*history.Locks, error) {
+	fis, err := r.SftpClient.ReadDir(path.Join(r.WD, DirLocks))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Join(errors.New("failed to list locks"), err)
+	}
+
+	locks := &history.Locks{Updated: time.Now().Unix()}
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".yaml") {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := r.ReadTo(path.Join(DirLocks, fi.Name()), &buf); err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to read lock %s", fi.Name()), err)
+		}
+		var l history.PathLock
+		if err := yaml.Unmarshal(buf.Bytes(), &l); err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to decode lock %s", fi.Name()), err)
+		}
+		locks.Locks = append(locks.Locks, l)
+	}
+	if err := locks.Save(); err != nil {
+		return nil, err
+	}
+	return locks, nil
+}
+
+// lockTargets returns the files of commit c the patterns select, and the
+// untracked files they name exactly.
+func (r *Remote) lockTargets(c *history.Commit, patterns []string) []string {
+	var targets []string
+	for _, f := range c.Files {
+		if f.Type != history.FileTypeDir && diff.MatchPath(f.Path, patterns) {
+			targets = append(targets, f.Path)
+		}
+	}
+	for _, p := range patterns {
+		p = path.Clean(filepath.ToSlash(p))
+		if slices.Contains(targets, p) || !filepath.IsLocal(p) {
+			continue
+		}
+		if fi, err := os.Lstat(filepath.Join(r.LocalWD(), filepath.FromSlash(p))); err == nil && fi.Mode().IsRegular() {
+			targets = append(targets, p)
+		}
+	}
+	slices.Sort(targets)
+	return targets
+}
+
+// LockPaths locks the files selected by the patterns for the user and
+// returns the newly locked paths. Files locked by someone else are an error
+// and nothing is locked then.
+func (r *Remote) LockPaths(patterns []string) ([]string, error) {
+	cur, err := r.Config.CurrentCommit()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to get current commit"), err)
+	}
+	targets := r.lockTargets(cur, patterns)
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no files match %v", patterns)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	var locked []string
+	err = r.WithLock(func() error {
+		locks, err := r.PathLocks()
+		if err != nil {
+			return err
+		}
+		var others []history.PathLock
+		for _, p := range targets {
+			if l, ok := locks.Get(p); ok && l.Owner != r.Config.User {
+				others = append(others, *l)
+			}
+		}
+		if len(others) != 0 {
+			return &LockedFilesError{Locks: others}
+		}
+
+		now := time.Now().Unix()
+		for _, p := range targets {
+			if _, ok := locks.Get(p); ok {
+				continue
+			}
+			l := history.PathLock{Path: p, Owner: r.Config.User, Host: host, Created: now}
+			data, err := yaml.Marshal(l)
+			if err != nil {
+				return errors.Join(fmt.Errorf("failed to encode lock of %s", p), err)
+			}
+			if err := r.Write(bytes.NewReader(data), r.pathLockPath(p)); err != nil {
+				return errors.Join(fmt.Errorf("failed to write lock of %s", p), err)
+			}
+			locks.Locks = append(locks.Locks, l)
+			locked = append(locked, p)
+		}
+		return locks.Save()
+	})
+	return locked, err
+}
+
+// UnlockPaths removes the locks of the locked files the patterns select and
+// returns their paths. Locks of someone else are only removed with force.
+func (r *Remote) UnlockPaths(patterns []string, force bool) ([]string, error) {
+	var unlocked []string
+	err := r.WithLock(func() error {
+		locks, err := r.PathLocks()
+		if err != nil {
+			return err
+		}
+		var selected, others []history.PathLock
+		for _, l := range locks.Locks {
+			if !diff.MatchPath(l.Path, patterns) {
+				continue
+			}
+			selected = append(selected, l)
+			if l.Owner != r.Config.User {
+				others = append(others, l)
+			}
+		}
+		if len(selected) == 0 {
+			return fmt.Errorf("no locked files match %v", patterns)
+		}
+		if len(others) != 0 && !force {
+			return errors.Join(&LockedFilesError{Locks: others}, errors.New("use --force to break their locks"))
+		}
+
+		for _, l := range selected {
+			if err := r.SftpClient.Remove(path.Join(r.WD, r.pathLockPath(l.Path))); err != nil && !os.IsNotExist(err) {
+				return errors.Join(fmt.Errorf("failed to remove lock of %s", l.Path), err)
+			}
+			unlocked = append(unlocked, l.Path)
+		}
+		locks.Locks = slices.DeleteFunc(locks.Locks, func(l history.PathLock) bool {
+			return slices.Contains(unlocked, l.Path)
+		})
+		return locks.Save()
+	})
+	return unlocked, err
+}
+
+// checkPathLocks refuses a commit that changes files locked by someone
+// else. A file counts as changed when it differs from all parents, so
+// merging the changes of the lock owner is fine.
+func (r *Remote) checkPathLocks(commit *history.Commit) error {
+	locks, err := r.PathLocks()
+	if err != nil {
+		return err
+	}
+	var parents []*history.Commit
+	var blocked []history.PathLock
+	for _, l := range locks.Locks {
+		if l.Owner == r.Config.User {
+			continue
+		}
+		if parents == nil {
+			for _, id := range commit.Parents {
+				p, err := history.Load(id)
+				if err != nil {
+					return errors.Join(fmt.Errorf("failed to load parent commit %x", id), err)
+				}
+				parents = append(parents, p)
+			}
+		}
+		f := fileOf(commit, l.Path)
+		changed := !slices.ContainsFunc(parents, func(p *history.Commit) bool {
+			return sameFile(f, fileOf(p, l.Path))
+		})
+		if changed {
+			blocked = append(blocked, l)
+		}
+	}
+	if len(blocked) != 0 {
+		return &LockedFilesError{Locks: blocked}
+	}
+	return nil
+}
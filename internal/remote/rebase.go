@@ -0,0 +1,117 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+	"slices"
+	"strings"
+)
+
+// Rebase moves the local changes onto head so they can be published on top
+// of it. The changes of the working tree are replayed like a checkout does,
+// files changed on both sides go through the conflict handling. Commits
+// that are checked out but not on the branch, like an uploaded commit whose
+// merge stopped, are replayed together with them; that needs a clean working
+// tree. Staged changes are kept as long as head did not change their files.
+//
+// The replayed commits are not published one by one, their changes are
+// squashed into the single commit made from the rebased working tree.
+//
+// The message the replayed changes are to be published with is returned:
+// msg, or the messages of the replayed commits. It is empty when only
+// uncommitted changes were moved and msg is empty. On conflicts the rebase
+// is recorded with msg and paths for scribe pull --continue. Commits have to
+// be pulled beforehand.
+func (r *Remote) Rebase(head *history.Commit, msg string, paths []string) (string, error) {
+	cur, err := r.Config.CurrentCommit()
+	if err != nil {
+		return "", errors.Join(errors.New("failed to get current commit"), err)
+	}
+	h, err := history.LoadAll()
+	if err != nil {
+		return "", errors.Join(errors.New("failed to load history"), err)
+	}
+
+	base := cur
+	var local int64
+	if !h.IsAncestor(cur.Created, head.Created) {
+		found, ok := h.MergeBase(cur.Created, head.Created)
+		if !ok {
+			return "", fmt.Errorf("commit %x has no common history with commit %x", cur.Created, head.Created)
+		}
+		if base, err = history.Load(found.Created); err != nil {
+			return "", errors.Join(errors.New("failed to load merge base"), err)
+		}
+		if changes, err := diff.LocalFromCommit(r.Config, cur); err != nil {
+			return "", errors.Join(errors.New("failed to diff local changes"), err)
+		} else if len(changes) != 0 {
+			return "", fmt.Errorf("commit %x is not on the branch, commit or stash the local changes before rebasing it", cur.Created)
+		}
+
+		replayed := h.Log(cur.Created)
+		replayed = slices.DeleteFunc(replayed, func(c *history.Commit) bool {
+			return h.IsAncestor(c.Created, base.Created)
+		})
+		if msg == "" {
+			// oldest first, like they were made
+			var msgs []string
+			for i := len(replayed) - 1; i >= 0; i-- {
+				msgs = append(msgs, replayed[i].Message)
+			}
+			msg = strings.Join(msgs, "\n\n")
+		}
+		log.Printf("replay %d commits since %x\n", len(replayed), base.Created)
+		local = cur.Created
+	}
+
+	idx, err := history.LoadIndex()
+	if err != nil {
+		return "", err
+	}
+	for _, e := range idx.Entries {
+		if !sameFile(fileOf(base, e.Path), fileOf(head, e.Path)) {
+			return "", fmt.Errorf("%s is staged and was changed on the remote, unstage it before rebasing", e.Path)
+		}
+	}
+
+	// the local changes are the ones since base, that is only saved once
+	// the checkout is done
+	prevCommit, prevDetached := r.Config.Commit, r.Config.Detached
+	r.Config.Commit = base.Created
+	r.Config.Detached = false
+
+	err = r.CheckoutCommit(head)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		state, ok, lerr := history.LoadConflictState()
+		if lerr != nil {
+			return "", lerr
+		}
+		if ok {
+			state.Operation = history.OperationRebase
+			state.Local = local
+			state.Message = msg
+			state.Paths = paths
+			if err := state.Save(); err != nil {
+				return "", err
+			}
+			conflict.Operation = state.Operation
+		}
+		return "", conflict
+	}
+	if err != nil {
+		r.Config.Commit, r.Config.Detached = prevCommit, prevDetached
+		if serr := r.Config.Save(); serr != nil {
+			err = errors.Join(err, errors.Join(errors.New("failed to save config"), serr))
+		}
+		return "", errors.Join(errors.New("failed to checkout commit"), err)
+	}
+	// head may be base already, then the checkout had nothing to do
+	if err := r.Config.Save(); err != nil {
+		return "", errors.Join(errors.New("failed to save config"), err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,195 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"scribe/internal/config"
+	"scribe/internal/history"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DirRefs holds the named refs, each a file containing a commit id.
+	DirRefs = "refs"
+	// DirBranches holds the heads of all branches but the default one,
+	// which lives in the HEAD file.
+	DirBranches = DirRefs + "/heads"
+)
+
+var refNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// hexPattern matches names that could be taken for a commit id.
+var hexPattern = regexp.MustCompile(`^[0-9A-Fa-f]+$`)
+
+// CheckRefName reports whether name can be used for a branch or tag.
+func CheckRefName(name string) error {
+	if !refNamePattern.MatchString(name) || strings.EqualFold(name, FileHead) {
+		return fmt.Errorf("invalid name %q, use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// checkNewRefName is CheckRefName for a branch or tag about to be created.
+// Names of hex digits only are refused as well, they read like commit ids.
+func checkNewRefName(name string) error {
+	if err := CheckRefName(name); err != nil {
+		return err
+	}
+	if hexPattern.MatchString(name) {
+		return fmt.Errorf("invalid name %q, it could be mistaken for a commit id", name)
+	}
+	return nil
+}
+
+func branchPath(name string) string {
+	if name == config.DefaultBranch {
+		return FileHead
+	}
+	return path.Join(DirBranches, name)
+}
+
+// readRef reads the commit id of the ref file p.
+func (r *Remote) readRef(p string) (int64, bool, error) {
+	rf, err := r.SftpClient.Open(path.Join(r.WD, p))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, errors.Join(fmt.Errorf("failed to open %s", p), err)
+	}
+	defer rf.Close()
+
+	cb, err := io.ReadAll(rf)
+	if err != nil {
+		return 0, false, errors.Join(fmt.Errorf("failed to read %s from remote", p), err)
+	}
+	ci, err := strconv.ParseInt(strings.TrimSpace(string(cb)), 10, 64)
+	if err != nil {
+		return 0, false, errors.Join(fmt.Errorf("failed to read commit number of %s", p), err)
+	}
+	return ci, true, nil
+}
+
+// writeRef points the ref file p to commit id. The id is written to a
+// temporary file that replaces the ref in one step, so readers never see a
+// partly written ref.
+func (r *Remote) writeRef(p string, id int64) error {
+	if err := r.Mkdir(path.Dir(p)); err != nil {
+		return err
+	}
+	token, err := newToken()
+	if err != nil {
+		return errors.Join(errors.New("failed to create temporary name"), err)
+	}
+	// the leading dot keeps it out of the ref names
+	tmp := path.Join(r.WD, path.Dir(p), "."+path.Base(p)+".tmp-"+token)
+	rf, err := r.SftpClient.Create(tmp)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to create %s on remote", tmp), err)
+	}
+	if _, err := fmt.Fprintf(rf, "%d", id); err != nil {
+		_ = rf.Close()
+		_ = r.SftpClient.Remove(tmp)
+		return errors.Join(fmt.Errorf("failed to write commit to %s on remote", p), err)
+	}
+	if err := rf.Close(); err != nil {
+		_ = r.SftpClient.Remove(tmp)
+		return errors.Join(fmt.Errorf("failed to write commit to %s on remote", p), err)
+	}
+	if err := r.SftpClient.PosixRename(tmp, path.Join(r.WD, p)); err != nil {
+		_ = r.SftpClient.Remove(tmp)
+		return errors.Join(fmt.Errorf("failed to replace %s on remote", p), err)
+	}
+	return nil
+}
+
+// GetBranchCommit returns the head commit of a branch.
+// Commits have to be pulled beforehand.
+func (r *Remote) GetBranchCommit(name string) (*history.Commit, error) {
+	if err := CheckRefName(name); err != nil {
+		return nil, err
+	}
+	id, ok, err := r.readRef(branchPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("branch %s does not exist", name)
+	}
+	c, err := history.Load(id)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to load head commit of branch %s locally", name), err)
+	}
+	return c, nil
+}
+
+// SetBranchCommit moves a branch to commit c under the remote lock.
+func (r *Remote) SetBranchCommit(name string, c *history.Commit) error {
+	if err := r.WithLock(func() error {
+		return r.writeRef(branchPath(name), c.Created)
+	}); err != nil {
+		return errors.Join(fmt.Errorf("failed to set head of branch %s", name), err)
+	}
+	return nil
+}
+
+// HasBranch reports whether the branch exists on the remote.
+func (r *Remote) HasBranch(name string) (bool, error) {
+	_, ok, err := r.readRef(branchPath(name))
+	return ok, err
+}
+
+// Branches returns the names of all branches, the default branch first.
+func (r *Remote) Branches() ([]string, error) {
+	fis, err := r.SftpClient.ReadDir(path.Join(r.WD, DirBranches))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Join(errors.New("failed to list branches"), err)
+	}
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		if !fi.IsDir() && CheckRefName(fi.Name()) == nil {
+			names = append(names, fi.Name())
+		}
+	}
+	slices.Sort(names)
+	return append([]string{config.DefaultBranch}, names...), nil
+}
+
+// CreateBranch creates a new branch pointing to commit c.
+func (r *Remote) CreateBranch(name string, c *history.Commit) error {
+	if err := checkNewRefName(name); err != nil {
+		return err
+	}
+	return r.WithLock(func() error {
+		if ok, err := r.HasBranch(name); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("branch %s already exists", name)
+		}
+		return r.SetBranchCommit(name, c)
+	})
+}
+
+// DeleteBranch removes a branch. Its commits stay on the remote.
+func (r *Remote) DeleteBranch(name string) error {
+	if err := CheckRefName(name); err != nil {
+		return err
+	}
+	if name == config.DefaultBranch {
+		return fmt.Errorf("the default branch %s can't be deleted", name)
+	}
+	return r.WithLock(func() error {
+		if err := r.SftpClient.Remove(path.Join(r.WD, branchPath(name))); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("branch %s does not exist", name)
+			}
+			return errors.Join(fmt.Errorf("failed to delete branch %s", name), err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,37 @@
+package remote
+
+import "testing"
+
+func TestCheckRefName(t *testing.T) {
+	tests := []struct {
+		name     string
+		valid    bool
+		validNew bool
+	}{
+		{"main", true, true},
+		{"feature-1.2_x", true, true},
+		{"v1.0", true, true},
+		{"HEAD", false, false},
+		{"head", false, false},
+		{"", false, false},
+		{".hidden", false, false},
+		{"-x", false, false},
+		{"a/b", false, false},
+		{"../../KEYS", false, false},
+		{"../tags/v1", false, false},
+		{"..", false, false},
+		{"a b", false, false},
+		{"cafe", true, false},
+		{"6ad63353", true, false},
+		{"DEADBEEF", true, false},
+		{"cafe1g", true, true},
+	}
+	for _, tt := range tests {
+		if err := CheckRefName(tt.name); (err == nil) != tt.valid {
+			t.Errorf("CheckRefName(%q) = %v, want valid %v", tt.name, err, tt.valid)
+		}
+		if err := checkNewRefName(tt.name); (err == nil) != tt.validNew {
+			t.Errorf("checkNewRefName(%q) = %v, want valid %v", tt.name, err, tt.validNew)
+		}
+	}
+}
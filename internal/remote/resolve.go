@@ -0,0 +1,51 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"scribe/internal/history"
+	"strings"
+)
+
+// ResolveCommit resolves a commit given on the command line: HEAD for the
+// head of the checked out branch, a branch name, a tag name or a commit id,
+// which may be shortened as long as it is unique. A full commit id wins over
+// a branch or tag of the same name. Commits have to be pulled beforehand.
+func (r *Remote) ResolveCommit(spec string) (*history.Commit, error) {
+	if strings.EqualFold(spec, FileHead) {
+		return r.GetHeadCommit()
+	}
+	if id, err := history.FindID(spec); err == nil && fmt.Sprintf("%x", id) == strings.ToLower(spec) {
+		return loadCommit(id)
+	}
+	if CheckRefName(spec) == nil {
+		if ok, err := r.HasBranch(spec); err != nil {
+			return nil, err
+		} else if ok {
+			return r.GetBranchCommit(spec)
+		}
+		if t, ok, err := r.GetTag(spec); err != nil {
+			return nil, err
+		} else if ok {
+			c, err := history.Load(t.Commit)
+			if err != nil {
+				return nil, errors.Join(fmt.Errorf("failed to load commit of tag %s", spec), err)
+			}
+			return c, nil
+		}
+	}
+
+	id, err := history.FindID(spec)
+	if err != nil {
+		return nil, err
+	}
+	return loadCommit(id)
+}
+
+func loadCommit(id int64) (*history.Commit, error) {
+	c, err := history.Load(id)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to load commit"), err)
+	}
+	return c, nil
+}
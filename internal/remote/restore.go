@@ -0,0 +1,86 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+)
+
+// Restore overwrites the local files selected by the patterns with their
+// version from commit c. Files that already match are left alone and files
+// the commit does not know are not touched. It returns the restored paths.
+func (r *Remote) Restore(c *history.Commit, patterns []string) ([]string, error) {
+	if err := c.LoadFiles(); err != nil {
+		return nil, err
+	}
+	localWd := r.LocalWD()
+	matched := false
+	var restored []string
+	for _, cf := range c.Files {
+		if !diff.MatchPath(cf.Path, patterns) {
+			continue
+		}
+		matched = true
+		same, err := diff.LocalSame(r.Config, filepath.Join(localWd, filepath.FromSlash(cf.Path)), cf)
+		if err != nil {
+			return restored, err
+		}
+		if same {
+			continue
+		}
+		if err := r.ReadObject(cf); err != nil {
+			return restored, errors.Join(fmt.Errorf("failed to restore %s", cf.Path), err)
+		}
+		restored = append(restored, cf.Path)
+	}
+	if !matched {
+		return nil, fmt.Errorf("no files of commit %x match %v", c.Created, patterns)
+	}
+	return restored, nil
+}
+
+// Reset discards all local changes against commit c. Modified and deleted
+// files are restored and untracked files that are not ignored are removed.
+func (r *Remote) Reset(c *history.Commit) error {
+	if err := c.LoadFiles(); err != nil {
+		return err
+	}
+	changes, err := diff.LocalFromCommit(r.Config, c)
+	if err != nil {
+		return errors.Join(errors.New("failed to diff local changes"), err)
+	}
+
+	// untracked files go first, they may occupy the place of a tracked one
+	for _, d := range changes {
+		if d.Type != diff.DiffTypeCreate {
+			continue
+		}
+		log.Printf("delete %s\n", d.Path)
+		if err := r.removeLocal(d.Path, c); err != nil {
+			return err
+		}
+	}
+
+	localWd := r.LocalWD()
+	for _, d := range changes {
+		if d.Type == diff.DiffTypeCreate {
+			continue
+		}
+		cf, _ := c.File(d.Path)
+		absPath := filepath.Join(localWd, filepath.FromSlash(d.Path))
+		if fi, err := os.Lstat(absPath); err == nil && fi.IsDir() && cf.Type != history.FileTypeDir {
+			if err := os.RemoveAll(absPath); err != nil {
+				return errors.Join(fmt.Errorf("failed to delete directory %s", d.Path), err)
+			}
+		}
+		log.Printf("restore %s\n", d.Path)
+		if err := r.ReadObject(cf); err != nil {
+			return errors.Join(fmt.Errorf("failed to restore %s", d.Path), err)
+		}
+	}
+	return nil
+}
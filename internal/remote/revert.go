@@ -0,0 +1,77 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+	"slices"
+	"strings"
+)
+
+// Revert creates and publishes a commit on top of head that undoes the file
+// changes of commit c. The published commit is returned, it is a merge if
+// head moved on meanwhile. Files that were changed again after c are conflicts;
+// they keep their version from head and are returned. Without force the
+// revert is refused when there are conflicts.
+func (r *Remote) Revert(c *history.Commit, head *history.Commit, msg string, force bool) (*history.Commit, []string, error) {
+	if len(c.Parents) == 0 {
+		return nil, nil, fmt.Errorf("commit %x has no parent to revert to", c.Created)
+	}
+	parent, err := history.Load(c.Parents[0])
+	if err != nil {
+		return nil, nil, errors.Join(errors.New("failed to load parent commit"), err)
+	}
+	if err := head.LoadFiles(); err != nil {
+		return nil, nil, err
+	}
+
+	files := make(map[string]history.CommitFile, len(head.Files))
+	for _, f := range head.Files {
+		files[f.Path] = f
+	}
+
+	var conflicts []string
+	changes := diff.Commits(parent, c)
+	for _, d := range changes {
+		cur, inHead := head.File(d.Path)
+		reverted, inC := c.File(d.Path)
+		if inHead != inC || (inHead && !cur.Same(reverted)) {
+			conflicts = append(conflicts, d.Path)
+			continue
+		}
+		if prev, ok := parent.File(d.Path); ok {
+			files[d.Path] = prev
+		} else {
+			delete(files, d.Path)
+		}
+	}
+	if len(conflicts) != 0 && !force {
+		return nil, conflicts, fmt.Errorf("%d files were changed after commit %x, use --force to revert the others", len(conflicts), c.Created)
+	}
+	if len(conflicts) == len(changes) {
+		return nil, conflicts, fmt.Errorf("nothing left to revert of commit %x", c.Created)
+	}
+
+	if msg == "" {
+		subject, _, _ := strings.Cut(c.Message, "\n")
+		msg = fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %x.", subject, c.Created)
+	}
+	revert := &history.Commit{
+		Parents: []int64{head.Created},
+		Message: msg,
+		Ignore:  head.Ignore,
+	}
+	for _, f := range files {
+		revert.Files = append(revert.Files, f)
+	}
+	slices.SortFunc(revert.Files, func(a, b history.CommitFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	published, err := r.publish(revert, PushMerge)
+	if err != nil {
+		return nil, conflicts, err
+	}
+	return published, conflicts, nil
+}
@@ -1,6 +1,7 @@
 package remote
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -9,13 +10,15 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"scribe/internal/compressed"
 	"scribe/internal/config"
+	"scribe/internal/crypt"
 	"scribe/internal/diff"
 	"scribe/internal/history"
 	"scribe/internal/ignore"
+	"scribe/internal/options"
 	"scribe/internal/util"
-	"strconv"
 	"strings"
 
 	"github.com/pkg/sftp"
@@ -28,6 +31,10 @@ type Remote struct {
 	SftpClient *sftp.Client
 	Config     *config.Config
 	WD         string
+	Format     *Format
+
+	// lease is set while the remote lock is held, see WithLock.
+	lease *Lease
 }
 
 const (
@@ -63,7 +70,34 @@ func (r *Remote) Close() error {
 	return nil
 }
 
+// Connect connects to the remote repository and checks that its format is
+// supported.
 func Connect(c *config.Config) (*Remote, error) {
+	r, err := Dial(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.CheckFormat(); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	if err := r.CheckEncryption(); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	if err := r.Unlock(); err != nil {
+		_ = r.Close()
+		return nil, errors.Join(errors.New("failed to unlock encrypted repository"), err)
+	}
+
+	return r, nil
+}
+
+// Dial connects to the remote repository without looking at its format.
+func Dial(c *config.Config) (*Remote, error) {
 	if c == nil {
 		return nil, errors.New("cannot connect, config is nil")
 	}
@@ -95,18 +129,59 @@ func (r *Remote) Mkdir(p string) error {
 	return nil
 }
 
-func (r *Remote) Write(f *os.File, p string) error {
+func (r *Remote) Write(f io.Reader, p string) error {
+	return r.write(f, p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+// errExists is returned by writeNew when the remote file exists already.
+var errExists = errors.New("remote file exists already")
+
+// writeNew is Write for a file that must not exist yet.
+func (r *Remote) writeNew(f io.Reader, p string) error {
+	err := r.write(f, p, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		// SFTP servers don't tell an existing file apart from other failures
+		if _, serr := r.SftpClient.Stat(path.Join(r.WD, p)); serr == nil {
+			return errExists
+		}
+	}
+	return err
+}
+
+func (r *Remote) write(f io.Reader, p string, flag int) error {
+	if err := r.CheckEncryption(); err != nil {
+		return err
+	}
 	if err := r.SftpClient.MkdirAll(path.Join(r.WD, filepath.Dir(p))); err != nil && !os.IsExist(err) {
 		return errors.Join(errors.New("failed to create parent directories"), err)
 	}
-	rf, err := r.SftpClient.Create(path.Join(r.WD, p))
+	rf, err := r.SftpClient.OpenFile(path.Join(r.WD, p), flag)
 	if err != nil {
 		return errors.Join(errors.New("failed to create remote file"), err)
 	}
-	_, err = compressed.Write(f, rf)
+	defer rf.Close()
+
+	if !r.Encrypted() {
+		_, err = compressed.Write(f, rf)
+		if err != nil {
+			return errors.Join(errors.New("failed to write compressed data"), err)
+		}
+		return nil
+	}
+
+	if r.Config.Key == nil {
+		return errors.New("repository is encrypted but no key is available")
+	}
+	ew, err := crypt.NewWriter(r.Config.Key, rf)
 	if err != nil {
+		return errors.Join(errors.New("failed to start encryption"), err)
+	}
+	if _, err := compressed.Write(f, ew); err != nil {
 		return errors.Join(errors.New("failed to write compressed data"), err)
 	}
+	if err := ew.Close(); err != nil {
+		return errors.Join(errors.New("failed to write encrypted data"), err)
+	}
 	return nil
 }
 
@@ -121,13 +196,28 @@ func (r *Remote) Read(remote string, local string) error {
 	}
 	defer f.Close()
 
+	return r.ReadTo(remote, f)
+}
+
+// ReadTo decompresses a remote file into w.
+func (r *Remote) ReadTo(remote string, w io.Writer) error {
 	rf, err := r.SftpClient.Open(path.Join(r.WD, remote))
 	if err != nil {
 		return errors.Join(errors.New("failed to open remote file"), err)
 	}
 	defer rf.Close()
 
-	_, err = compressed.Read(rf, f)
+	var src io.Reader = rf
+	if r.Encrypted() {
+		if r.Config.Key == nil {
+			return errors.New("repository is encrypted but no key is available")
+		}
+		if src, err = crypt.NewReader(r.Config.Key, rf); err != nil {
+			return errors.Join(errors.New("failed to start decryption"), err)
+		}
+	}
+
+	_, err = compressed.Read(src, w)
 	if err != nil {
 		return errors.Join(errors.New("failed to write compressed data"), err)
 	}
@@ -135,10 +225,73 @@ func (r *Remote) Read(remote string, local string) error {
 	return nil
 }
 
+// CommitPath adds a tracked working tree entry to the commit, uploading its
+// object if necessary. prev is the entry in the parent commit, if any.
+func (r *Remote) CommitPath(absPath string, repoPath string, d fs.DirEntry, prev *history.Commit, c *history.Commit) error {
+	switch {
+	case d.IsDir():
+		c.Files = append(c.Files, history.CommitFile{Path: repoPath, Type: history.FileTypeDir})
+		return nil
+	case d.Type()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(absPath)
+		if err != nil {
+			return errors.Join(fmt.Errorf("failed to read symlink %s", absPath), err)
+		}
+		return r.CommitSymlink(target, repoPath, c)
+	}
+
+	f, err := os.Open(absPath)
+	if err != nil {
+		return errors.Join(errors.New("failed to open file"), err)
+	}
+	defer f.Close()
+	if err := r.CommitFile(f, repoPath, c); err != nil {
+		return err
+	}
+
+	// windows has no executable bits, keep the ones that were committed before
+	if runtime.GOOS == "windows" && prev != nil {
+		if pf, ok := prev.File(repoPath); ok {
+			c.Files[len(c.Files)-1].Mode = pf.Mode
+		}
+	}
+	return nil
+}
+
+// CommitSymlink adds a symlink to the commit. The link is stored as its
+// target path instead of following it.
+func (r *Remote) CommitSymlink(target string, path string, c *history.Commit) error {
+	cf := history.CommitFile{Path: path, Type: history.FileTypeSymlink, Size: int64(len(target))}
+
+	if h, err := r.Config.HashReader(strings.NewReader(target)); err != nil {
+		return errors.Join(errors.New("failed to calculate symlink hash"), err)
+	} else {
+		cf.Hash = h
+	}
+
+	if has, err := r.HasObject(cf.Hash); err != nil {
+		return errors.Join(errors.New("failed to check object existence"), err)
+	} else if !has {
+		if err := r.WriteObject(strings.NewReader(target), cf.Hash); err != nil {
+			return errors.Join(errors.New("failed to write object"), err)
+		}
+	}
+
+	c.Files = append(c.Files, cf)
+	return nil
+}
+
 func (r *Remote) CommitFile(f *os.File, path string, c *history.Commit) error {
 	cf := history.CommitFile{Path: path}
 
-	if h, err := util.HashReader(f); err != nil {
+	if fi, err := f.Stat(); err != nil {
+		return errors.Join(errors.New("failed to stat file"), err)
+	} else {
+		cf.Mode = fi.Mode().Perm()
+		cf.Size = fi.Size()
+	}
+
+	if h, err := r.Config.HashReader(f); err != nil {
 		return errors.Join(errors.New("failed to calculate file hash"), err)
 	} else {
 		cf.Hash = h
@@ -159,12 +312,27 @@ func (r *Remote) CommitFile(f *os.File, path string, c *history.Commit) error {
 	return nil
 }
 
-func hashToObjectPath(h string) string {
-	return h[:1] + "/" + h[1:2] + "/" + h[2:8] + "/" + h[8:]
+func hashToObjectPath(h string) (string, error) {
+	if err := util.CheckHash(h); err != nil {
+		return "", err
+	}
+	return h[:1] + "/" + h[1:2] + "/" + h[2:8] + "/" + h[8:], nil
+}
+
+func objectPath(h string) (string, error) {
+	p, err := hashToObjectPath(h)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(DirObjects, p), nil
 }
 
 func (r *Remote) HasObject(h string) (bool, error) {
-	_, err := r.SftpClient.Stat(path.Join(r.WD, DirObjects, hashToObjectPath(h)))
+	op, err := objectPath(h)
+	if err != nil {
+		return false, err
+	}
+	_, err = r.SftpClient.Stat(path.Join(r.WD, op))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
@@ -175,20 +343,184 @@ func (r *Remote) HasObject(h string) (bool, error) {
 	return true, nil
 }
 
-func (r *Remote) WriteObject(f *os.File, h string) error {
-	if err := r.Write(f, path.Join(DirObjects, hashToObjectPath(h))); err != nil {
+func (r *Remote) WriteObject(f io.Reader, h string) error {
+	op, err := objectPath(h)
+	if err != nil {
+		return err
+	}
+	if err := r.Write(f, op); err != nil {
 		return errors.Join(errors.New("failed to write object file"), err)
 	}
 	return nil
 }
 
+// ReadObject restores a commit file into the working tree, including its
+// type and executable bit.
 func (r *Remote) ReadObject(cf history.CommitFile) error {
-	if err := r.Read(path.Join(DirObjects, hashToObjectPath(cf.Hash)), cf.Path); err != nil {
+	lfp, err := r.workingPath(cf.Path)
+	if err != nil {
+		return err
+	}
+
+	switch cf.Type {
+	case history.FileTypeDir:
+		if err := os.MkdirAll(lfp, 0764); err != nil {
+			return errors.Join(errors.New("failed to create directory"), err)
+		}
+		return nil
+	case history.FileTypeSymlink:
+		var target strings.Builder
+		if err := r.ReadVerified(cf.Hash, cf.Path, &target); err != nil {
+			return errors.Join(errors.New("failed to read object file"), err)
+		}
+		if err := os.MkdirAll(filepath.Dir(lfp), 0764); err != nil {
+			return errors.Join(errors.New("failed to create parent directories"), err)
+		}
+		if err := os.Remove(lfp); err != nil && !os.IsNotExist(err) {
+			return errors.Join(errors.New("failed to remove file"), err)
+		}
+		if err := writeSymlink(target.String(), lfp); err != nil {
+			return errors.Join(errors.New("failed to create symlink"), err)
+		}
+		return nil
+	}
+
+	// a symlink at the target path would be written through otherwise
+	if fi, err := os.Lstat(lfp); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(lfp); err != nil {
+			return errors.Join(errors.New("failed to remove symlink"), err)
+		}
+	}
+	if err := r.readVerifiedFile(cf.Hash, cf.Path, lfp); err != nil {
 		return errors.Join(errors.New("failed to read object file"), err)
 	}
+	if cf.Mode != 0 && runtime.GOOS != "windows" {
+		if err := os.Chmod(lfp, cf.Mode); err != nil {
+			return errors.Join(errors.New("failed to set file mode"), err)
+		}
+	}
 	return nil
 }
 
+// workingPath returns the absolute path of a repository path in the working
+// tree. Paths from the remote are not trusted, so anything leaving the
+// working tree is rejected, as is writing through a symlinked parent
+// directory, which a commit could otherwise use to reach outside of it.
+func (r *Remote) workingPath(repoPath string) (string, error) {
+	rel := filepath.FromSlash(repoPath)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("refusing path %s outside of the working tree", repoPath)
+	}
+	localWd := r.LocalWD()
+	dir := localWd
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", errors.Join(fmt.Errorf("failed to stat %s", dir), err)
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("refusing to write %s through the symlink %s", repoPath, dir)
+		}
+	}
+	return filepath.Join(localWd, rel), nil
+}
+
+// writeSymlink creates a symlink at p pointing to target. Creating symlinks
+// needs extra privileges on Windows, so there the target is written to a
+// plain file instead when that fails.
+func writeSymlink(target string, p string) error {
+	err := os.Symlink(target, p)
+	if err == nil || runtime.GOOS != "windows" {
+		return err
+	}
+	log.Printf("Could not create symlink %s, writing its target to a plain file\n", p)
+	return os.WriteFile(p, []byte(target), 0644)
+}
+
+// WriteTrees encodes the commit files into tree objects, uploads the trees
+// that are not known yet and sets the root tree of the commit.
+func (r *Remote) WriteTrees(c *history.Commit) error {
+	root, trees, err := history.BuildTrees(c.Files, r.Config.HashReader)
+	if err != nil {
+		return errors.Join(errors.New("failed to build trees"), err)
+	}
+
+	for h, data := range trees {
+		// the local cache may hold trees that never made it to the remote,
+		// so only the remote tells which ones can be skipped
+		if ok, err := r.HasObject(h); err != nil {
+			return err
+		} else if ok && history.HasTree(h) {
+			continue
+		}
+		if err := r.writeTree(h, data); err != nil {
+			return errors.Join(fmt.Errorf("failed to write tree %s", h), err)
+		}
+	}
+
+	c.Tree = root
+	return nil
+}
+
+func (r *Remote) writeTree(h string, data []byte) error {
+	tp, err := history.TreePath(h)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(tp), 0764); err != nil {
+		return errors.Join(errors.New("failed to create tree cache directory"), err)
+	}
+	tmp := tp + ".tmp"
+	if err := os.WriteFile(tmp, data, 0664); err != nil {
+		return errors.Join(errors.New("failed to write tree file"), err)
+	}
+	f, err := os.Open(tmp)
+	if err != nil {
+		return errors.Join(errors.New("failed to open tree file"), err)
+	}
+	defer f.Close()
+	if err := r.WriteObject(f, h); err != nil {
+		return err
+	}
+	return os.Rename(tmp, tp)
+}
+
+// PullTree downloads a tree and all of its subtrees into the local tree cache.
+func (r *Remote) PullTree(h string) error {
+	if history.HasTree(h) {
+		return nil
+	}
+	tp, err := history.TreePath(h)
+	if err != nil {
+		return err
+	}
+
+	// the tree is only moved into the cache after its subtrees are cached
+	if err := r.readVerifiedFile(h, "tree "+h, tp+".tmp"); err != nil {
+		return errors.Join(fmt.Errorf("failed to read tree %s", h), err)
+	}
+	t, err := history.DecodeTree(tp + ".tmp")
+	if err != nil {
+		return err
+	}
+	for _, e := range t.Entries {
+		if e.Type != history.EntryTypeTree {
+			continue
+		}
+		if err := r.PullTree(e.Hash); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tp+".tmp", tp)
+}
+
 func (r *Remote) WriteCommit(f *os.File, c *history.Commit) error {
 	if err := r.Write(f, path.Join(DirCommits, c.FileName())); err != nil {
 		return errors.Join(errors.New("failed to write commit file"), err)
@@ -196,18 +528,46 @@ func (r *Remote) WriteCommit(f *os.File, c *history.Commit) error {
 	return nil
 }
 
-func (r *Remote) SetHeadCommit(c *history.Commit) error {
-	rf, err := r.SftpClient.Create(path.Join(r.WD, FileHead))
+// uploadCommit signs and saves a new commit and uploads it. Commit ids are
+// timestamps, so someone else may have taken the id since the commits were
+// pulled. The remote file is created exclusively and the commit gets the
+// next id when it exists already, it is signed again with the same signer
+// because renumbering drops the signature.
+func (r *Remote) uploadCommit(commit *history.Commit) error {
+	signer, err := r.Signer()
 	if err != nil {
-		return errors.Join(errors.New("failed to create head file on remote"), err)
+		return errors.Join(errors.New("failed to load signing key"), err)
 	}
-	defer rf.Close()
+	for {
+		if err := signCommit(signer, commit); err != nil {
+			return errors.Join(errors.New("failed to sign commit"), err)
+		}
+		if err := commit.Save(); err != nil {
+			return errors.Join(errors.New("failed to save commit"), err)
+		}
 
-	_, err = fmt.Fprintf(rf, "%d", c.Created)
-	if err != nil {
-		return errors.Join(errors.New("failed to write commit to head file on remote"), err)
+		cf, err := commit.Open()
+		if err != nil {
+			return errors.Join(errors.New("failed to open commit file"), err)
+		}
+		err = r.writeNew(cf, path.Join(DirCommits, commit.FileName()))
+		_ = cf.Close()
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, errExists) {
+			return errors.Join(errors.New("failed to write commit file"), err)
+		}
+
+		log.Printf("commit id %x is taken on the remote, use the next one\n", commit.Created)
+		if err := commit.Renumber(); err != nil {
+			return errors.Join(errors.New("failed to renumber commit"), err)
+		}
 	}
-	return nil
+}
+
+// SetHeadCommit moves the checked out branch to commit c.
+func (r *Remote) SetHeadCommit(c *history.Commit) error {
+	return r.SetBranchCommit(r.Config.CurrentBranch(), c)
 }
 
 func (r *Remote) RepoIsEmpty() (bool, error) {
@@ -231,122 +591,246 @@ func (r *Remote) RepoIsEmpty() (bool, error) {
 	return len(fis) == 0, nil
 }
 
-func (r *Remote) Commit(msg string) error {
-	commit := &history.Commit{
+// Commit snapshots the working tree and publishes it as the new HEAD. When
+// patterns are given, only the selected paths are taken from the working
+// tree and all others keep their version from the current commit. push
+// decides what happens when the remote head moved on, see publish.
+func (r *Remote) Commit(msg string, patterns []string, push Push) error {
+	return r.commitWorkingTree(&history.Commit{
+		Parents: []int64{r.Config.Commit},
 		Message: msg,
 		Ignore:  r.Config.Ignore,
-	}
+	}, patterns, push)
+}
 
-	m := ignore.GetMatcher(r.Config)
+// commitWorkingTree adds the files of the working tree to commit and
+// publishes it, see Commit.
+func (r *Remote) commitWorkingTree(commit *history.Commit, patterns []string, push Push) error {
+	prev, err := r.Config.CurrentCommit()
+	if err != nil {
+		return errors.Join(errors.New("failed to get current commit"), err)
+	}
 
 	localWd := r.LocalWD()
+	selected := func(p string) bool {
+		return len(patterns) == 0 || diff.MatchPath(p, patterns)
+	}
 
-	if err := filepath.WalkDir(localWd, func(absPath string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	if err := ignore.Walk(r.Config, localWd, func(repoPath string, absPath string, d fs.DirEntry) error {
+		if !selected(repoPath) {
+			return nil
 		}
+		return r.CommitPath(absPath, repoPath, d, prev, commit)
+	}); err != nil {
+		return errors.Join(fmt.Errorf("failed to walk repo dir %s", localWd), err)
+	}
 
-		repoPath, err := filepath.Rel(localWd, absPath)
-		if err != nil {
-			return errors.Join(errors.New("failed to get relative path"), err)
-		}
-		gitPath := util.TrimSliceEmptyString(strings.Split(repoPath, string(filepath.Separator)))
-		isDir := d.IsDir()
-		if m.Match(gitPath, isDir) {
-			// excluded from ignore
-			if isDir {
-				return filepath.SkipDir
+	if len(patterns) != 0 {
+		matched := len(commit.Files) != 0
+		for _, f := range prev.Files {
+			if selected(f.Path) {
+				matched = true
 			} else {
-				return nil
+				commit.Files = append(commit.Files, f)
 			}
 		}
-		if isDir {
-			return nil
-		}
-		f, err := os.Open(absPath)
-		if err != nil {
-			return errors.Join(errors.New("failed to open file"), err)
+		if !matched {
+			return fmt.Errorf("no files match %v", patterns)
 		}
-		defer f.Close()
-		return r.CommitFile(f, strings.Join(gitPath, "/"), commit)
-	}); err != nil {
-		return errors.Join(fmt.Errorf("failed to walk repo dir %s", localWd), err)
 	}
 
-	if err := commit.Save(); err != nil {
-		return errors.Join(errors.New("failed to save commit"), err)
+	return r.publishLocal(commit, push)
+}
+
+// Push decides what publishing does when the head of the branch moved on
+// since the commit was started.
+type Push int
+
+const (
+	// PushReject refuses to publish the commit.
+	PushReject Push = iota
+	// PushMerge merges the head into the commit and publishes the merge.
+	PushMerge
+	// PushForce publishes the commit anyway, the commits on top of the old
+	// head are no longer on the branch.
+	PushForce
+)
+
+// RejectedError is returned when a commit is not published because the head
+// of the branch moved on since the commit was started.
+type RejectedError struct {
+	Branch string
+	Head   int64
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("head of branch %s moved on to commit %x, merge its changes first or use --force to overwrite it", e.Branch, e.Head)
+}
+
+// publish uploads the trees and the signed commit and makes it the head of
+// the checked out branch. If the head is not contained in the commit's
+// parents, push decides whether the commit is rejected, the head is merged
+// into it and the merge commit is published instead, or the head is
+// overwritten. Changes to files someone else locked are refused. The
+// published commit is returned, the local commit pointer is left to the
+// caller.
+func (r *Remote) publish(commit *history.Commit, push Push) (*history.Commit, error) {
+	// commit ids are timestamps, knowing the commits of others avoids
+	// taking one of theirs when they committed within the same second
+	if err := r.PullCommits(); err != nil {
+		return nil, errors.Join(errors.New("failed to pull commits"), err)
 	}
 
-	if cf, err := commit.Open(); err != nil {
-		return errors.Join(errors.New("failed to open commit file"), err)
-	} else {
-		defer cf.Close()
-		if err := r.WriteCommit(cf, commit); err != nil {
-			return errors.Join(errors.New("failed to write commit"), err)
+	if err := r.checkPathLocks(commit); err != nil {
+		return nil, err
+	}
+
+	// reject before anything is uploaded
+	if push == PushReject {
+		head, err := r.GetHeadCommit()
+		if err != nil {
+			return nil, errors.Join(errors.New("failed to get head commit"), err)
+		}
+		if diverged, err := r.Diverged(head, commit.Parents...); err != nil {
+			return nil, errors.Join(errors.New("failed to check for divergence"), err)
+		} else if diverged {
+			return nil, &RejectedError{Branch: r.Config.CurrentBranch(), Head: head.Created}
 		}
 	}
 
-	if err := r.SetHeadCommit(commit); err != nil {
-		return errors.Join(errors.New("failed to set commit as head"), err)
+	if err := r.WriteTrees(commit); err != nil {
+		return nil, errors.Join(errors.New("failed to write trees"), err)
 	}
 
-	return nil
-}
+	if err := r.uploadCommit(commit); err != nil {
+		return nil, err
+	}
 
-func (r *Remote) InitialCommit() error {
-	wd, err := os.Getwd()
+	headID, moved, err := r.advanceHead(commit, push == PushForce, true)
 	if err != nil {
-		return errors.Join(errors.New("failed to get working directory"), err)
+		return nil, err
+	}
+	if moved {
+		return commit, nil
+	}
+	if push != PushMerge {
+		// the head moved while the commit was uploaded
+		return nil, &RejectedError{Branch: r.Config.CurrentBranch(), Head: headID}
 	}
 
-	m := ignore.GetMatcher(r.Config)
-	commit := &history.Commit{
-		Message: "init",
-		Ignore:  r.Config.Ignore,
+	if err := r.PullCommits(); err != nil {
+		return nil, errors.Join(errors.New("failed to pull commits"), err)
+	}
+	head, err := history.Load(headID)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to load head commit"), err)
+	}
+	log.Printf("remote head %x moved, merge it into commit %x\n", head.Created, commit.Created)
+	result, err := r.MergeCommits(commit, head)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to merge remote head"), err)
+	}
+	if len(result.Conflicts) != 0 {
+		return nil, &MergeConflictError{Conflicts: result.Conflicts}
 	}
+	return r.publish(&history.Commit{
+		Parents: []int64{commit.Created, head.Created},
+		Message: fmt.Sprintf("Merge %x into %x", head.Created, commit.Created),
+		Ignore:  commit.Ignore,
+		Files:   result.Files,
+	}, push)
+}
 
-	if err := filepath.WalkDir(wd, func(absPath string, d fs.DirEntry, err error) error {
+// advanceHead makes commit the head of the checked out branch if it contains
+// the current head, or anyway with force. Reading and writing the head
+// happens under the remote lock, so no other commit can sneak in between.
+// With checkLocks the file locks are checked again under the lock, someone
+// could have locked a file the commit changes while it was uploaded.
+// It returns the head it found and whether it was replaced.
+func (r *Remote) advanceHead(commit *history.Commit, force bool, checkLocks bool) (int64, bool, error) {
+	branch := r.Config.CurrentBranch()
+	var headID int64
+	var moved bool
+	err := r.WithLock(func() error {
+		id, ok, err := r.readRef(branchPath(branch))
 		if err != nil {
-			return err
+			return errors.Join(errors.New("failed to get head commit"), err)
+		} else if !ok {
+			return fmt.Errorf("branch %s does not exist", branch)
 		}
-		repoPath, err := filepath.Rel(r.LocalWD(), absPath)
+		headID = id
+
+		h, err := history.LoadAll()
 		if err != nil {
-			return errors.Join(errors.New("failed to get relative path"), err)
-		}
-		gitPath := util.TrimSliceEmptyString(strings.Split(repoPath, string(filepath.Separator)))
-		isDir := d.IsDir()
-		if m.Match(gitPath, isDir) {
-			// excluded from ignore
-			if isDir {
-				return filepath.SkipDir
-			} else {
+			return errors.Join(errors.New("failed to load history"), err)
+		}
+		// a head we don't know can't be an ancestor of the commit
+		if !h.IsAncestor(id, commit.Created) {
+			if !force {
 				return nil
 			}
+			log.Printf("overwrite remote head %x with commit %x\n", id, commit.Created)
 		}
-		if isDir {
-			return nil
+		if checkLocks {
+			if err := r.checkPathLocks(commit); err != nil {
+				return err
+			}
 		}
-		f, err := os.Open(absPath)
-		if err != nil {
-			return errors.Join(errors.New("failed to open file"), err)
+		if err := r.SetHeadCommit(commit); err != nil {
+			return errors.Join(errors.New("failed to set commit as head"), err)
 		}
-		defer f.Close()
-		return r.CommitFile(f, strings.Join(gitPath, "/"), commit)
+		moved = true
+		return nil
+	})
+	return headID, moved, err
+}
+
+// publishLocal publishes a commit made from the working tree and makes it the
+// local commit. A merge with a moved head is checked out afterwards. When the
+// merge conflicts, the commit stays uploaded and checked out, detached from
+// the branch, for the caller to merge the head into it. A rejected commit
+// leaves the local commit alone.
+func (r *Remote) publishLocal(commit *history.Commit, push Push) error {
+	published, err := r.publish(commit, push)
+	var conflict *MergeConflictError
+	if err != nil && !errors.As(err, &conflict) {
+		return err
+	}
+
+	r.Config.Commit = commit.Created
+	r.Config.Detached = conflict != nil
+	if err := r.Config.Save(); err != nil {
+		return errors.Join(errors.New("failed to save config"), err)
+	}
+
+	if conflict != nil {
+		return errors.Join(fmt.Errorf("remote head moved and can't be merged automatically, commit %x is uploaded but not on the branch", commit.Created), err)
+	}
+	if published != commit {
+		log.Printf("checkout merge commit %x\n", published.Created)
+		return r.CheckoutCommit(published)
+	}
+	return nil
+}
+
+func (r *Remote) InitialCommit() error {
+	commit := &history.Commit{
+		Message: "init",
+		Ignore:  r.Config.Ignore,
+	}
+
+	if err := ignore.Walk(r.Config, r.LocalWD(), func(repoPath string, absPath string, d fs.DirEntry) error {
+		return r.CommitPath(absPath, repoPath, d, nil, commit)
 	}); err != nil {
 		return errors.Join(errors.New("failed to walk repo dir"), err)
 	}
 
-	if err := commit.Save(); err != nil {
-		return errors.Join(errors.New("failed to save commit"), err)
+	if err := r.WriteTrees(commit); err != nil {
+		return errors.Join(errors.New("failed to write trees"), err)
 	}
 
-	if cf, err := commit.Open(); err != nil {
-		return errors.Join(errors.New("failed to open commit file"), err)
-	} else {
-		defer cf.Close()
-		if err := r.WriteCommit(cf, commit); err != nil {
-			return errors.Join(errors.New("failed to write commit"), err)
-		}
+	if err := r.uploadCommit(commit); err != nil {
+		return err
 	}
 
 	if err := r.SetHeadCommit(commit); err != nil {
@@ -361,54 +845,108 @@ func (r *Remote) InitialCommit() error {
 	return nil
 }
 
+// PullCommits downloads the commits and their trees from the remote. Commits
+// that are new or changed are verified against the local allowed signers
+// before they are stored, unverified ones are only taken with
+// --allow-unverified.
 func (r *Remote) PullCommits() error {
 	fileInfos, err := r.SftpClient.ReadDir(path.Join(r.WD, DirCommits))
 	if err != nil {
 		return errors.Join(errors.New("failed to read commits directory on remote"), err)
 	}
 
+	if changed, err := r.PullAllowedSigners(); err != nil {
+		return errors.Join(errors.New("failed to check allowed signers"), err)
+	} else if changed {
+		log.Println("the allowed signers on the remote differ from the local ones, review them with scribe signers list --remote and accept them with scribe signers trust")
+	}
+	as, err := r.LocalAllowedSigners()
+	if err != nil {
+		return err
+	}
+
+	var unverified []error
 	for _, fileInfo := range fileInfos {
 		name := fileInfo.Name()
 		if fileInfo.IsDir() || !strings.HasSuffix(name, ".yaml") {
 			continue
 		}
 
-		if err := r.Read(path.Join(DirCommits, name), path.Join(".scribe", name)); err != nil {
+		var b bytes.Buffer
+		if err := r.ReadTo(path.Join(DirCommits, name), &b); err != nil {
 			return errors.Join(fmt.Errorf("failed to read remote file %s", name), err)
 		}
+		lfp := filepath.Join(r.LocalWD(), history.HistoryDirName, name)
+		if local, err := os.ReadFile(lfp); err == nil && bytes.Equal(local, b.Bytes()) {
+			continue
+		}
+		// hashes become paths, a malformed commit is never stored
+		if err := checkCommitFile(name, b.Bytes()); err != nil {
+			return err
+		}
+		if err := verifyCommitFile(as, name, b.Bytes()); err != nil {
+			if !options.FlagAllowUnverified {
+				// without allowed signers all of them fail the same way
+				if !errors.Is(err, errNoAllowedSigners) || len(unverified) == 0 {
+					unverified = append(unverified, err)
+				}
+				continue
+			}
+			log.Printf("pull unverified commit: %v\n", err)
+		}
+		if err := os.WriteFile(lfp, b.Bytes(), 0664); err != nil {
+			return errors.Join(fmt.Errorf("failed to write commit file %s", name), err)
+		}
+	}
+	if len(unverified) != 0 {
+		return errors.Join(append([]error{errors.New("refusing to pull unverified commits, use --allow-unverified to override")}, unverified...)...)
 	}
 
-	return nil
-}
-
-func (r *Remote) GetHeadCommit() (*history.Commit, error) {
-	rf, err := r.SftpClient.Open(path.Join(r.WD, FileHead))
+	h, err := history.LoadAll()
 	if err != nil {
-		return nil, errors.Join(errors.New("failed to open head file"), err)
+		return errors.Join(errors.New("failed to load history"), err)
+	}
+	for _, c := range h {
+		if len(c.Tree) == 0 {
+			continue
+		}
+		if err := r.PullTree(c.Tree); err != nil {
+			return errors.Join(fmt.Errorf("failed to pull tree of commit %x", c.Created), err)
+		}
 	}
-	defer rf.Close()
 
-	cb, err := io.ReadAll(rf)
-	if err != nil {
-		return nil, errors.Join(errors.New("failed to read head file from remote"), err)
+	return nil
+}
+
+func checkCommitFile(name string, b []byte) error {
+	var c history.Commit
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return errors.Join(fmt.Errorf("failed to decode commit file %s", name), err)
 	}
-	ci, err := strconv.ParseInt(string(cb), 10, 64)
-	if err != nil {
-		return nil, errors.Join(errors.New("failed to read head commit number from remote"), err)
+	if err := c.CheckHashes(); err != nil {
+		return errors.Join(fmt.Errorf("invalid commit file %s", name), err)
 	}
-	cf, err := os.Open(filepath.Join(r.LocalWD(), ".scribe", fmt.Sprintf("%x.yaml", ci)))
+	return nil
+}
+
+// GetHeadCommit returns the head commit of the checked out branch.
+func (r *Remote) GetHeadCommit() (*history.Commit, error) {
+	return r.GetBranchCommit(r.Config.CurrentBranch())
+}
+
+// Diverged reports whether none of the commit ids descends from the given
+// head commit. Commits have to be pulled beforehand.
+func (r *Remote) Diverged(head *history.Commit, ids ...int64) (bool, error) {
+	h, err := history.LoadAll()
 	if err != nil {
-		return nil, errors.Join(errors.New("failed to open head commit file locally"), err)
+		return false, errors.Join(errors.New("failed to load history"), err)
 	}
-	defer cf.Close()
-
-	c := &history.Commit{}
-	yd := yaml.NewDecoder(cf)
-	if err := yd.Decode(c); err != nil {
-		return nil, errors.Join(errors.New("failed to decode local commit file"), err)
+	for _, id := range ids {
+		if h.IsAncestor(head.Created, id) {
+			return false, nil
+		}
 	}
-
-	return c, nil
+	return true, nil
 }
 
 func (r *Remote) CloneCommit(c *history.Commit) error {
@@ -417,7 +955,7 @@ func (r *Remote) CloneCommit(c *history.Commit) error {
 	// get files from remote
 	for _, f := range c.Files {
 		if err := r.ReadObject(f); err != nil {
-			return errors.Join(errors.New("failed to read object from remote"), err)
+			return errors.Join(fmt.Errorf("failed to read object for %s of commit %x from remote", f.Path, c.Created), err)
 		}
 	}
 
@@ -445,75 +983,98 @@ func (r *Remote) CheckoutCommit(c *history.Commit) error {
 		}
 	}
 
-	localWd := r.LocalWD()
-
-	// get files from remote
-	for _, f := range c.Files {
-		// check if file exists
-		ccf, exists := currentCommit.File(f.Path)
-		if exists {
-			// check if file has changed on remote
-			if ccf.Hash == f.Hash {
+	// collect all conflicts before touching the working tree
+	var conflicts []string
+	for _, d := range locallyChanged {
+		cf, inCurrent := currentCommit.File(d.Path)
+		tf, inTarget := c.File(d.Path)
+		if inCurrent == inTarget && (!inCurrent || cf.Same(tf)) {
+			// unchanged by the checkout
+			continue
+		}
+		if !inTarget && d.Type == diff.DiffTypeDelete {
+			continue
+		}
+		if inTarget {
+			absPath := filepath.Join(r.LocalWD(), filepath.FromSlash(d.Path))
+			if same, err := diff.LocalSame(r.Config, absPath, tf); err != nil {
+				return err
+			} else if same {
 				continue
 			}
-			// check if file has changed locally
-			if locallyChanged.HasModifyOrDelete(f.Path) {
-				panic(fmt.Sprintf("checkout failed: conflict %s (local and remote modified/deleted)\n", f.Path))
-			}
-		} else {
-			// locally also created?
-			if locallyChanged.HasCreate(f.Path) {
-				panic(fmt.Sprintf("checkout failed: conflict %s (local and remote created)\n", f.Path))
-			}
 		}
+		conflicts = append(conflicts, d.Path)
+	}
+	if len(conflicts) != 0 {
+		log.Printf("%d local changes conflict with commit %x\n", len(conflicts), c.Created)
+		return r.checkoutWithConflicts(currentCommit, c)
 	}
 
-	// delete files that shouldn't exist
-	m := ignore.GetMatcher(r.Config)
-	if err := filepath.WalkDir(localWd, func(absPath string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		repoPath, err := filepath.Rel(localWd, absPath)
-		if err != nil {
-			return err
-		}
-		repoPath = path.Clean(repoPath)
+	if err := r.updateWorkingTree(currentCommit, c, locallyChanged); err != nil {
+		return err
+	}
 
-		gitPath := util.TrimSliceEmptyString(strings.Split(repoPath, string(filepath.Separator)))
-		isDir := d.IsDir()
-		if m.Match(gitPath, isDir) {
-			// excluded from ignore
-			if isDir {
-				return filepath.SkipDir
-			} else {
-				return nil
-			}
-		}
+	r.Config.Commit = c.Created
+	return r.Config.Save()
+}
 
-		if isDir {
+// updateWorkingTree moves the working tree from commit cur to commit c. Files
+// the commit does not know are deleted unless they were created or modified
+// locally, changed files are fetched from the remote.
+func (r *Remote) updateWorkingTree(cur *history.Commit, c *history.Commit, locallyChanged diff.DiffList) error {
+	var remove []string
+	if err := ignore.Walk(r.Config, r.LocalWD(), func(repoPath string, absPath string, d fs.DirEntry) error {
+		if locallyChanged.HasCreate(repoPath) || locallyChanged.HasModify(repoPath) {
 			return nil
 		}
-
-		repoUnixPath := strings.Join(gitPath, "/")
-
-		if locallyChanged.HasCreate(repoUnixPath) {
+		if _, ok := c.File(repoPath); ok {
 			return nil
 		}
-
-		for _, f := range c.Files {
-			if f.Path == repoPath {
-				return nil
-			}
-		}
-
-		log.Printf("delete %s\n", repoPath)
-		return os.Remove(absPath)
+		remove = append(remove, repoPath)
+		return nil
 	}); err != nil {
 		return errors.Join(errors.New("error while walking local repo path"), err)
 	}
+	for _, repoPath := range remove {
+		log.Printf("delete %s\n", repoPath)
+		if err := r.removeLocal(repoPath, c); err != nil {
+			return err
+		}
+	}
 
-	r.Config.Commit = c.Created
-	return r.Config.Save()
+	for _, f := range c.Files {
+		if ccf, exists := cur.File(f.Path); exists && ccf.Same(f) {
+			continue
+		}
+		if err := r.ReadObject(f); err != nil {
+			return errors.Join(fmt.Errorf("failed to read object for %s of commit %x from remote", f.Path, c.Created), err)
+		}
+	}
+	return nil
+}
+
+// removeLocal deletes a working tree entry and the parent directories that
+// become empty by it, unless the commit tracks them as empty directories.
+func (r *Remote) removeLocal(repoPath string, c *history.Commit) error {
+	localWd := r.LocalWD()
+	lfp, err := r.workingPath(repoPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(lfp); err != nil && !os.IsNotExist(err) {
+		return errors.Join(fmt.Errorf("failed to delete %s", repoPath), err)
+	}
+	for dir := path.Dir(repoPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, ok := c.File(dir); ok {
+			break
+		}
+		entries, err := os.ReadDir(filepath.Join(localWd, filepath.FromSlash(dir)))
+		if err != nil || len(entries) != 0 {
+			break
+		}
+		if err := os.Remove(filepath.Join(localWd, filepath.FromSlash(dir))); err != nil {
+			return errors.Join(fmt.Errorf("failed to delete directory %s", dir), err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,198 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"scribe/internal/history"
+	"scribe/internal/sign"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// FileAllowedSigners lists the keys trusted to sign commits, in the allowed
+// signers format of ssh-keygen. The remote copy is shared by the team, the
+// local copy in the history directory is what commits are verified against.
+// It is taken over on clone and only replaced on request afterwards.
+const FileAllowedSigners = "allowed_signers"
+
+// Signer loads the configured signing key, it is nil without one. The
+// passphrase is asked once, so keep the signer for all commits of a run.
+func (r *Remote) Signer() (ssh.Signer, error) {
+	if len(r.Config.SigningKey) == 0 {
+		return nil, nil
+	}
+	return sign.LoadSigner(r.Config.SigningKey)
+}
+
+// signCommit signs the commit with signer, unsigned commits stay as they are
+// without one.
+func signCommit(signer ssh.Signer, c *history.Commit) error {
+	if signer == nil {
+		return nil
+	}
+	if err := c.AssignID(); err != nil {
+		return err
+	}
+	data, err := c.SignedData()
+	if err != nil {
+		return errors.Join(errors.New("failed to encode commit for signing"), err)
+	}
+	if c.Signature, err = sign.Sign(signer, sign.Namespace, data); err != nil {
+		return errors.Join(errors.New("failed to sign commit"), err)
+	}
+	return nil
+}
+
+func (r *Remote) localAllowedSignersPath() string {
+	return filepath.Join(r.LocalWD(), history.HistoryDirName, FileAllowedSigners)
+}
+
+// LocalAllowedSigners reads the local allowed signers list. It is nil if
+// signature verification is not set up for this clone.
+func (r *Remote) LocalAllowedSigners() (sign.AllowedSigners, error) {
+	b, err := os.ReadFile(r.localAllowedSignersPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Join(errors.New("failed to read local allowed signers"), err)
+	}
+	return sign.ParseAllowedSigners(b)
+}
+
+func (r *Remote) SaveLocalAllowedSigners(as sign.AllowedSigners) error {
+	return os.WriteFile(r.localAllowedSignersPath(), as.Bytes(), 0664)
+}
+
+// RemoteAllowedSigners reads the allowed signers list shared on the remote.
+func (r *Remote) RemoteAllowedSigners() (sign.AllowedSigners, error) {
+	rf, err := r.SftpClient.Open(path.Join(r.WD, FileAllowedSigners))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Join(errors.New("failed to open remote allowed signers"), err)
+	}
+	defer rf.Close()
+
+	b, err := io.ReadAll(rf)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to read remote allowed signers"), err)
+	}
+	return sign.ParseAllowedSigners(b)
+}
+
+func (r *Remote) WriteRemoteAllowedSigners(as sign.AllowedSigners) error {
+	rf, err := r.SftpClient.Create(path.Join(r.WD, FileAllowedSigners))
+	if err != nil {
+		return errors.Join(errors.New("failed to create remote allowed signers"), err)
+	}
+	defer rf.Close()
+
+	if _, err := rf.Write(as.Bytes()); err != nil {
+		return errors.Join(errors.New("failed to write remote allowed signers"), err)
+	}
+	return nil
+}
+
+// PullAllowedSigners offers to take over the remote allowed signers list if
+// there is no local one yet. Its keys are shown and only trusted once the
+// user confirms them. It reports whether the remote list differs from the
+// local one otherwise.
+func (r *Remote) PullAllowedSigners() (bool, error) {
+	remote, err := r.RemoteAllowedSigners()
+	if err != nil {
+		return false, err
+	}
+	local, err := r.LocalAllowedSigners()
+	if err != nil {
+		return false, err
+	}
+	if local == nil {
+		if len(remote) == 0 {
+			return false, nil
+		}
+		var keys strings.Builder
+		for _, s := range remote {
+			fmt.Fprintf(&keys, "%s %s\n", s.Principals, ssh.FingerprintSHA256(s.Key))
+		}
+		trust := false
+		if err := huh.NewForm(huh.NewGroup(
+			huh.NewConfirm().
+				Title("trust the allowed signers shared on the remote?").
+				Description("Commits are verified against these keys, compare them with their owners:\n" + keys.String()).
+				Affirmative("Trust").
+				Negative("Cancel").
+				Value(&trust),
+		)).Run(); err != nil || !trust {
+			log.Println("the allowed signers on the remote are not trusted, review them with scribe signers list --remote and accept them with scribe signers trust")
+			return false, nil
+		}
+		return false, r.SaveLocalAllowedSigners(remote)
+	}
+	return !bytes.Equal(local.Bytes(), remote.Bytes()), nil
+}
+
+// errNoAllowedSigners is returned when commits are to be verified but no
+// key is trusted yet.
+var errNoAllowedSigners = errors.New("no allowed signers are set up to verify commits, trust the ones on the remote with scribe signers trust or add keys with scribe signers add")
+
+// VerifyCommit checks the commit signature against the local allowed signers
+// and returns the principals of the signer. Without local allowed signers no
+// commit can be verified.
+func (r *Remote) VerifyCommit(c *history.Commit) (string, error) {
+	as, err := r.LocalAllowedSigners()
+	if err != nil {
+		return "", err
+	}
+	if len(as) == 0 {
+		return "", errNoAllowedSigners
+	}
+	return verifyWith(as, c)
+}
+
+// verifyCommitFile checks a commit file read from the remote before it is
+// stored locally. The commit has to be stored under its own id, so a signed
+// commit cannot be replayed under another one.
+func verifyCommitFile(as sign.AllowedSigners, name string, b []byte) error {
+	if len(as) == 0 {
+		return errNoAllowedSigners
+	}
+	var c history.Commit
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return errors.Join(fmt.Errorf("failed to decode commit file %s", name), err)
+	}
+	if c.FileName() != name {
+		return fmt.Errorf("commit file %s contains commit %x", name, c.Created)
+	}
+	_, err := verifyWith(as, &c)
+	return err
+}
+
+func verifyWith(as sign.AllowedSigners, c *history.Commit) (string, error) {
+	if len(c.Signature) == 0 {
+		return "", fmt.Errorf("commit %x is not signed", c.Created)
+	}
+	data, err := c.SignedData()
+	if err != nil {
+		return "", errors.Join(errors.New("failed to encode commit for verification"), err)
+	}
+	key, err := sign.Verify(c.Signature, sign.Namespace, data)
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("commit %x has an invalid signature", c.Created), err)
+	}
+	principals, ok := as.Find(key)
+	if !ok {
+		return "", fmt.Errorf("commit %x is signed by %s, which is not an allowed signer", c.Created, ssh.FingerprintSHA256(key))
+	}
+	return principals, nil
+}
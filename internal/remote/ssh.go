@@ -2,6 +2,7 @@ package remote
 
 import (
 	"fmt"
+	"log"
 	"net"
 	"os/user"
 	"path/filepath"
@@ -20,11 +21,12 @@ func hostKeyCallback() ssh.HostKeyCallback {
 		if util.Exists(path) {
 			if hostKeyCallback, err := knownhosts.New(path); err == nil {
 				return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-					if options.FlagForce {
-						return nil
-					}
 					ok := false
 					if err := hostKeyCallback(hostname, remote, key); err != nil {
+						if options.FlagForce {
+							log.Printf("ignore host key check because of --force: %v\n", err)
+							return nil
+						}
 						if fErr := huh.NewForm(huh.NewGroup(
 							huh.NewConfirm().
 								Title(err.Error()).
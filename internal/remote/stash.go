@@ -0,0 +1,194 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"scribe/internal/diff"
+	"scribe/internal/history"
+)
+
+// StashPush moves all local changes against the checked out commit into a
+// new stash and resets the working tree to the commit.
+func (r *Remote) StashPush(msg string) (*history.Stash, error) {
+	prev, err := r.Config.CurrentCommit()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to get current commit"), err)
+	}
+	changes, err := diff.LocalFromCommit(r.Config, prev)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to diff local changes"), err)
+	}
+	if len(changes) == 0 {
+		return nil, errors.New("no local changes to stash")
+	}
+
+	s, err := history.NewStash(prev.Created, msg)
+	if err != nil {
+		return nil, err
+	}
+	localWd := r.LocalWD()
+	for _, d := range changes {
+		sf := history.StashFile{CommitFile: history.CommitFile{Path: d.Path}}
+		if pf, ok := prev.File(d.Path); ok {
+			sf.BaseHash = pf.Hash
+		}
+		if d.Type == diff.DiffTypeDelete {
+			sf.Deleted = true
+			s.Files = append(s.Files, sf)
+			continue
+		}
+
+		absPath := filepath.Join(localWd, filepath.FromSlash(d.Path))
+		fi, err := os.Lstat(absPath)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to stat %s", d.Path), err)
+		}
+		cf, err := diff.Stat(r.Config, absPath, d.Path, fi)
+		if err != nil {
+			return nil, err
+		}
+		sf.CommitFile = cf
+		if err := stashContent(absPath, cf, s.ContentPath(cf.Hash)); err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to stash %s", d.Path), err)
+		}
+		s.Files = append(s.Files, sf)
+	}
+	if err := s.Save(); err != nil {
+		return nil, err
+	}
+
+	if err := r.Reset(prev); err != nil {
+		return s, errors.Join(errors.New("failed to reset working tree"), err)
+	}
+	return s, nil
+}
+
+// stashContent copies a local file into the stash, symlinks are kept as
+// their target path.
+func stashContent(absPath string, cf history.CommitFile, dst string) error {
+	switch cf.Type {
+	case history.FileTypeDir:
+		return nil
+	case history.FileTypeSymlink:
+		target, err := os.Readlink(absPath)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dst, []byte(target), 0644)
+	}
+	return copyFile(absPath, dst, 0644)
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// StashApply reapplies the changes of a stash to the working tree. A file is
+// a conflict when its local state is neither the one the stash was made on
+// nor the stashed one. Nothing is applied when there are conflicts.
+func (r *Remote) StashApply(s *history.Stash) ([]string, error) {
+	cur, err := r.Config.CurrentCommit()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to get current commit"), err)
+	}
+
+	localWd := r.LocalWD()
+	var conflicts, pending []history.StashFile
+	for _, sf := range s.Files {
+		absPath := filepath.Join(localWd, filepath.FromSlash(sf.Path))
+		local, exists, err := r.localFile(absPath, sf.Path)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case sf.Deleted && !exists, !sf.Deleted && exists && local.Type == sf.Type && local.Hash == sf.Hash:
+			// already in the stashed state
+		case sf.BaseHash == "" && !exists, sf.BaseHash != "" && exists && local.Hash == sf.BaseHash:
+			pending = append(pending, sf)
+		default:
+			conflicts = append(conflicts, sf)
+		}
+	}
+	if len(conflicts) != 0 {
+		paths := make([]string, len(conflicts))
+		for i, sf := range conflicts {
+			paths[i] = sf.Path
+		}
+		return paths, fmt.Errorf("%d stashed files conflict with the working tree", len(conflicts))
+	}
+
+	for _, sf := range pending {
+		absPath, err := r.workingPath(sf.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.applyStashFile(s, sf, absPath, cur); err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to apply %s", sf.Path), err)
+		}
+	}
+	return nil, nil
+}
+
+// localFile describes the local file at absPath, if there is one.
+func (r *Remote) localFile(absPath string, repoPath string) (history.CommitFile, bool, error) {
+	fi, err := os.Lstat(absPath)
+	if os.IsNotExist(err) {
+		return history.CommitFile{}, false, nil
+	} else if err != nil {
+		return history.CommitFile{}, false, errors.Join(fmt.Errorf("failed to stat %s", repoPath), err)
+	}
+	cf, err := diff.Stat(r.Config, absPath, repoPath, fi)
+	return cf, true, err
+}
+
+func (r *Remote) applyStashFile(s *history.Stash, sf history.StashFile, absPath string, cur *history.Commit) error {
+	if sf.Deleted {
+		return r.removeLocal(sf.Path, cur)
+	}
+	return placeFile(absPath, sf.CommitFile, s.ContentPath(sf.Hash))
+}
+
+// placeFile writes a file to the working tree from a local copy of its
+// content. Symlinks are stored as their target path.
+func placeFile(absPath string, cf history.CommitFile, contentPath string) error {
+	if cf.Type == history.FileTypeDir {
+		return os.MkdirAll(absPath, 0764)
+	}
+	if err := os.MkdirAll(filepath.Dir(absPath), 0764); err != nil {
+		return err
+	}
+	if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if cf.Type == history.FileTypeSymlink {
+		target, err := os.ReadFile(contentPath)
+		if err != nil {
+			return err
+		}
+		return writeSymlink(string(target), absPath)
+	}
+	if err := copyFile(contentPath, absPath, 0644); err != nil {
+		return err
+	}
+	if cf.Mode != 0 && runtime.GOOS != "windows" {
+		return os.Chmod(absPath, cf.Mode)
+	}
+	return nil
+}
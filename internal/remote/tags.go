@@ -0,0 +1,117 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DirTags holds the tags, stored like commits so they are compressed and,
+// in encrypted repositories, encrypted.
+const DirTags = DirRefs + "/tags"
+
+// Tag is an immutable name for a commit with an optional annotation.
+type Tag struct {
+	Name    string `yaml:"name"`
+	Commit  int64  `yaml:"commit"`
+	Message string `yaml:"message,omitempty"`
+	Author  string `yaml:"author,omitempty"`
+	Created int64  `yaml:"created_at"`
+}
+
+func tagPath(name string) string {
+	return path.Join(DirTags, name)
+}
+
+// GetTag reads a tag, it reports false if there is no such tag.
+func (r *Remote) GetTag(name string) (*Tag, bool, error) {
+	if err := CheckRefName(name); err != nil {
+		return nil, false, err
+	}
+	if _, err := r.SftpClient.Stat(path.Join(r.WD, tagPath(name))); os.IsNotExist(err) {
+		return nil, false, nil
+	}
+
+	var buf bytes.Buffer
+	if err := r.ReadTo(tagPath(name), &buf); err != nil {
+		return nil, false, errors.Join(fmt.Errorf("failed to read tag %s", name), err)
+	}
+	t := &Tag{}
+	if err := yaml.Unmarshal(buf.Bytes(), t); err != nil {
+		return nil, false, errors.Join(fmt.Errorf("failed to decode tag %s", name), err)
+	}
+	return t, true, nil
+}
+
+// Tags returns all tags sorted by name.
+func (r *Remote) Tags() ([]*Tag, error) {
+	fis, err := r.SftpClient.ReadDir(path.Join(r.WD, DirTags))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Join(errors.New("failed to list tags"), err)
+	}
+	tags := make([]*Tag, 0, len(fis))
+	for _, fi := range fis {
+		if fi.IsDir() || CheckRefName(fi.Name()) != nil {
+			continue
+		}
+		t, ok, err := r.GetTag(fi.Name())
+		if err != nil {
+			return nil, err
+		} else if ok {
+			tags = append(tags, t)
+		}
+	}
+	slices.SortFunc(tags, func(a, b *Tag) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return tags, nil
+}
+
+// CreateTag stores a new tag. Existing tags are never overwritten.
+func (r *Remote) CreateTag(t *Tag) error {
+	if err := checkNewRefName(t.Name); err != nil {
+		return err
+	}
+	if t.Created == 0 {
+		t.Created = time.Now().Unix()
+	}
+	data, err := yaml.Marshal(t)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to encode tag %s", t.Name), err)
+	}
+
+	return r.WithLock(func() error {
+		if _, ok, err := r.GetTag(t.Name); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("tag %s already exists", t.Name)
+		}
+		if err := r.Write(bytes.NewReader(data), tagPath(t.Name)); err != nil {
+			return errors.Join(fmt.Errorf("failed to write tag %s", t.Name), err)
+		}
+		return nil
+	})
+}
+
+// DeleteTag removes a tag, the commit it points to stays.
+func (r *Remote) DeleteTag(name string) error {
+	if err := CheckRefName(name); err != nil {
+		return err
+	}
+	return r.WithLock(func() error {
+		if err := r.SftpClient.Remove(path.Join(r.WD, tagPath(name))); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("tag %s does not exist", name)
+			}
+			return errors.Join(fmt.Errorf("failed to delete tag %s", name), err)
+		}
+		return nil
+	})
+}
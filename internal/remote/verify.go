@@ -0,0 +1,104 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"scribe/internal/history"
+	"scribe/internal/util"
+)
+
+// IntegrityError is returned when the content of an object does not match
+// its hash, because it was corrupted or tampered with on the remote.
+type IntegrityError struct {
+	Hash   string
+	Actual string
+	Path   string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("object %s for %s is corrupted, its content hashes to %s", e.Hash, e.Path, e.Actual)
+}
+
+// ReadVerified decompresses the object h into w while hashing it. p is the
+// path the object is read for, it is only used in errors. On an
+// IntegrityError w has already received the corrupted content.
+func (r *Remote) ReadVerified(h string, p string, w io.Writer) error {
+	op, err := objectPath(h)
+	if err != nil {
+		return err
+	}
+	hash := r.Config.NewHash()
+	if err := r.ReadTo(op, io.MultiWriter(w, hash)); err != nil {
+		return err
+	}
+	if actual := util.EncodeHash(hash); actual != h {
+		return &IntegrityError{Hash: h, Actual: actual, Path: p}
+	}
+	return nil
+}
+
+// readVerifiedTemp reads the object h into a new temporary file, which is
+// only handed out once its hash matches. The caller closes and removes it.
+func (r *Remote) readVerifiedTemp(h string, p string) (*os.File, error) {
+	tmpDir := filepath.Join(r.LocalWD(), history.HistoryDirName, "tmp")
+	if err := os.MkdirAll(tmpDir, 0764); err != nil {
+		return nil, errors.Join(errors.New("failed to create temporary directory"), err)
+	}
+	tmp, err := os.CreateTemp(tmpDir, "object-*")
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to create temporary file"), err)
+	}
+	if err := r.ReadVerified(h, p, tmp); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// readVerifiedFile reads the object h into a temporary file and only moves it
+// to dst once its hash matches, so corrupted content never lands there.
+func (r *Remote) readVerifiedFile(h string, p string, dst string) error {
+	tmp, err := r.readVerifiedTemp(h, p)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Close(); err != nil {
+		return errors.Join(errors.New("failed to write temporary file"), err)
+	}
+	// temporary files are private, the checked out file is not
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return errors.Join(errors.New("failed to set file mode"), err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0764); err != nil {
+		return errors.Join(errors.New("failed to create parent directories"), err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return errors.Join(errors.New("failed to move object into place"), err)
+	}
+	return nil
+}
+
+// ReadObjectTo writes the verified content of a commit file to w. The object
+// is read into a temporary file first, so w never sees corrupted content.
+func (r *Remote) ReadObjectTo(cf history.CommitFile, w io.Writer) error {
+	tmp, err := r.readVerifiedTemp(cf.Hash, cf.Path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return errors.Join(errors.New("failed to seek temporary file"), err)
+	}
+	if _, err := io.Copy(w, tmp); err != nil {
+		return errors.Join(errors.New("failed to copy object content"), err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readYaml decodes an uncompressed yaml file from the remote repository.
+// It reports false if the file does not exist.
+func (r *Remote) readYaml(name string, v any) (bool, error) {
+	rf, err := r.SftpClient.Open(path.Join(r.WD, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Join(fmt.Errorf("failed to open %s", name), err)
+	}
+	defer rf.Close()
+
+	b, err := io.ReadAll(rf)
+	if err != nil {
+		return false, errors.Join(fmt.Errorf("failed to read %s", name), err)
+	}
+	if err := yaml.Unmarshal(b, v); err != nil {
+		return false, errors.Join(fmt.Errorf("failed to decode %s", name), err)
+	}
+	return true, nil
+}
+
+// writeYaml encodes v into an uncompressed yaml file on the remote repository.
+func (r *Remote) writeYaml(name string, v any) error {
+	var buf bytes.Buffer
+	ye := yaml.NewEncoder(&buf)
+	if err := ye.Encode(v); err != nil {
+		return errors.Join(fmt.Errorf("failed to encode %s", name), err)
+	}
+	if err := ye.Close(); err != nil {
+		return errors.Join(fmt.Errorf("failed to encode %s", name), err)
+	}
+
+	if err := r.SftpClient.MkdirAll(path.Join(r.WD, path.Dir(name))); err != nil && !os.IsExist(err) {
+		return errors.Join(errors.New("failed to create parent directories"), err)
+	}
+	rf, err := r.SftpClient.Create(path.Join(r.WD, name))
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to create %s on remote", name), err)
+	}
+	defer rf.Close()
+
+	if _, err := rf.Write(buf.Bytes()); err != nil {
+		return errors.Join(fmt.Errorf("failed to write %s on remote", name), err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package sign
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"golang.org/x/crypto/ssh"
+)
+
+type (
+	// AllowedSigners is a list of trusted keys in the allowed signers format
+	// of ssh-keygen: "principals [options] key-type base64-key [comment]".
+	AllowedSigners []AllowedSigner
+
+	AllowedSigner struct {
+		Principals string
+		Key        ssh.PublicKey
+	}
+)
+
+func ParseAllowedSigners(data []byte) (AllowedSigners, error) {
+	var as AllowedSigners
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		found := false
+		// options are optional, the key starts at the first field that parses
+		for j := 1; j < len(fields) && !found; j++ {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[j:], " ")))
+			if err != nil {
+				continue
+			}
+			as = append(as, AllowedSigner{Principals: fields[0], Key: key})
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid allowed signers line %d", i+1)
+		}
+	}
+	return as, nil
+}
+
+func (as AllowedSigners) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, s := range as {
+		fmt.Fprintf(&buf, "%s %s", s.Principals, ssh.MarshalAuthorizedKey(s.Key))
+	}
+	return buf.Bytes()
+}
+
+// Find returns the principals allowed to sign with key.
+func (as AllowedSigners) Find(key ssh.PublicKey) (string, bool) {
+	for _, s := range as {
+		if bytes.Equal(s.Key.Marshal(), key.Marshal()) {
+			return s.Principals, true
+		}
+	}
+	return "", false
+}
+
+// LoadSigner reads an OpenSSH ed25519 private key, asking for its passphrase
+// if it is encrypted.
+func LoadSigner(name string) (ssh.Signer, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to read signing key"), err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(b)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		var passphrase string
+		if err := huh.NewForm(huh.NewGroup(
+			huh.NewInput().
+				Title(fmt.Sprintf("Passphrase for %s", name)).
+				EchoMode(huh.EchoModePassword).
+				Value(&passphrase),
+		)).Run(); err != nil {
+			return nil, err
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(b, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to parse signing key"), err)
+	}
+
+	if t := signer.PublicKey().Type(); t != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("signing key must be an ed25519 key, got %s", t)
+	}
+	return signer, nil
+}
@@ -0,0 +1,105 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Signatures use the SSHSIG format of ssh-keygen -Y sign, which is also what
+// git uses for SSH commit signatures.
+
+const (
+	magic         = "SSHSIG"
+	hashAlgorithm = "sha512"
+	pemType       = "SSH SIGNATURE"
+
+	// Namespace separates commit signatures from signatures made with the
+	// same key for other purposes.
+	Namespace = "scribe-commit"
+)
+
+type signedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          string
+}
+
+type signatureBlob struct {
+	Version       uint32
+	PublicKey     string
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     string
+}
+
+func toSign(namespace string, msg []byte) []byte {
+	h := sha512.Sum512(msg)
+	return append([]byte(magic), ssh.Marshal(signedData{
+		Namespace:     namespace,
+		HashAlgorithm: hashAlgorithm,
+		Hash:          string(h[:]),
+	})...)
+}
+
+// Sign creates an armored signature of msg.
+func Sign(signer ssh.Signer, namespace string, msg []byte) (string, error) {
+	sig, err := signer.Sign(rand.Reader, toSign(namespace, msg))
+	if err != nil {
+		return "", err
+	}
+	blob := append([]byte(magic), ssh.Marshal(signatureBlob{
+		Version:       1,
+		PublicKey:     string(signer.PublicKey().Marshal()),
+		Namespace:     namespace,
+		HashAlgorithm: hashAlgorithm,
+		Signature:     string(ssh.Marshal(sig)),
+	})...)
+	return string(pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: blob})), nil
+}
+
+// Verify checks an armored signature of msg and returns the key that made it.
+// Whether that key is trusted is up to the caller.
+func Verify(armored string, namespace string, msg []byte) (ssh.PublicKey, error) {
+	block, _ := pem.Decode([]byte(armored))
+	if block == nil || block.Type != pemType {
+		return nil, errors.New("invalid signature armor")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(magic)) {
+		return nil, errors.New("invalid signature magic")
+	}
+
+	var blob signatureBlob
+	if err := ssh.Unmarshal(block.Bytes[len(magic):], &blob); err != nil {
+		return nil, errors.Join(errors.New("invalid signature"), err)
+	}
+	if blob.Version != 1 {
+		return nil, fmt.Errorf("unsupported signature version %d", blob.Version)
+	}
+	if blob.Namespace != namespace {
+		return nil, fmt.Errorf("signature namespace %q does not match %q", blob.Namespace, namespace)
+	}
+	if blob.HashAlgorithm != hashAlgorithm {
+		return nil, fmt.Errorf("unsupported signature hash algorithm %s", blob.HashAlgorithm)
+	}
+
+	pub, err := ssh.ParsePublicKey([]byte(blob.PublicKey))
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid signature public key"), err)
+	}
+	sig := &ssh.Signature{}
+	if err := ssh.Unmarshal([]byte(blob.Signature), sig); err != nil {
+		return nil, errors.Join(errors.New("invalid signature"), err)
+	}
+	if err := pub.Verify(toSign(namespace, msg), sig); err != nil {
+		return nil, errors.Join(errors.New("signature does not match"), err)
+	}
+	return pub, nil
+}
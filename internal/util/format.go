@@ -0,0 +1,53 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FormatSize formats a byte count with binary units.
+func FormatSize(size int64) string {
+	const unit = 1024
+	abs := size
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := abs / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime parses an absolute date in local time, or a duration like
+// "36h" that is taken as the time that long ago.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(strings.TrimSuffix(s, " ago")); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, errors.New("invalid date " + s + ", use YYYY-MM-DD [HH:MM[:SS]] or a duration like 36h")
+}
+
+// FormatTime formats a unix timestamp in local time.
+func FormatTime(unix int64) string {
+	return time.Unix(unix, 0).Local().Format("Mon Jan 2 15:04:05 2006 -0700")
+}
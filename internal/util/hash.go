@@ -3,13 +3,33 @@ package util
 import (
 	hash "crypto/sha256"
 	"encoding/base64"
+	"fmt"
+	gohash "hash"
 	"io"
 )
 
+func NewHash() gohash.Hash {
+	return hash.New()
+}
+
+// EncodeHash encodes the sum of h the way object hashes are written.
+func EncodeHash(h gohash.Hash) string {
+	return base64.URLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// CheckHash refuses anything that is not an encoded object hash. Hashes are
+// read from remote commits and trees and end up in file paths.
+func CheckHash(h string) error {
+	if b, err := base64.URLEncoding.DecodeString(h); err != nil || len(b) != hash.Size {
+		return fmt.Errorf("invalid object hash %q", h)
+	}
+	return nil
+}
+
 func HashReader(r io.Reader) (string, error) {
-	h := hash.New()
+	h := NewHash()
 	if _, err := io.Copy(h, r); err != nil {
 		return "", err
 	}
-	return base64.URLEncoding.EncodeToString(h.Sum(nil)), nil
+	return EncodeHash(h), nil
 }